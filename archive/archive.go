@@ -0,0 +1,183 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 打包时不保存的 xattr, overlay 的元数据由 whiteout 文件表示
+const overlayXattrPrefix = "trusted.overlay."
+
+// 把可写层 dir 打包成一个镜像层写入 w
+// aufs 的元数据不打包, overlay 的字符设备 whiteout 和 opaque 目录转换为 .wh. 文件, 和 docker 的镜像层格式一致
+func TarLayer(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// 同一个 inode 第二次出现时写成硬链接
+	inodes := map[uint64]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := info.Name()
+		if strings.HasPrefix(name, WhiteoutMetaPrefix) && name != WhiteoutOpaqueDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isOverlayWhiteout(info) {
+			whiteout := filepath.Join(filepath.Dir(rel), WhiteoutPrefix+name)
+			return writeEmptyFile(tw, whiteout, info)
+		}
+
+		if err := writeEntry(tw, path, rel, info, inodes); err != nil {
+			return err
+		}
+		if info.IsDir() && isOverlayOpaque(path) {
+			return writeEmptyFile(tw, filepath.Join(rel, WhiteoutOpaqueDir), info)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// 把 rootfs 中的修改 changes 打包成一个镜像层写入 w, 删除的文件写成 .wh. 文件
+// 用于 vfs 这种没有单独可写层的驱动, changes 由 ChangesDirs 得到
+func ExportChanges(rootfs string, changes []Change, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	inodes := map[uint64]string{}
+	for _, change := range changes {
+		rel := strings.TrimPrefix(change.Path, "/")
+		if change.Kind == ChangeDelete {
+			whiteout := filepath.Join(filepath.Dir(rel), WhiteoutPrefix+filepath.Base(rel))
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: whiteout, Mode: 0600}); err != nil {
+				return err
+			}
+			continue
+		}
+		path := filepath.Join(rootfs, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, path, rel, info, inodes); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 把文件 path 以 rel 为名写入 tw, inodes 记录已经写过的硬链接文件
+func writeEntry(tw *tar.Writer, path, rel string, info os.FileInfo, inodes map[uint64]string) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// 用户名在宿主机和镜像中可能不一致, 只保留 uid 和 gid
+	hdr.Uname = ""
+	hdr.Gname = ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if hdr.Linkname, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && stat.Nlink > 1 {
+		if first, ok := inodes[stat.Ino]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+		} else {
+			inodes[stat.Ino] = rel
+		}
+	}
+	if err := addXattrs(hdr, path); err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		if err := copyFile(tw, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeEmptyFile(tw *tar.Writer, name string, info os.FileInfo) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		ModTime:  info.ModTime(),
+	})
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func isOverlayOpaque(path string) bool {
+	buf := make([]byte, 1)
+	n, err := syscall.Getxattr(path, overlayOpaqueXattr, buf)
+	return err == nil && n == 1 && buf[0] == 'y'
+}
+
+// 保存文件的扩展属性, 例如 security.capability
+func addXattrs(hdr *tar.Header, path string) error {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size <= 0 {
+		// 文件系统不支持 xattr 时忽略
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil
+	}
+	for _, key := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if key == "" || strings.HasPrefix(key, overlayXattrPrefix) {
+			continue
+		}
+		valueSize, err := unix.Lgetxattr(path, key, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, valueSize)
+		if _, err := unix.Lgetxattr(path, key, value); err != nil {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords["SCHILY.xattr."+key] = string(value)
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+type ChangeType int
+
+// 和 docker 的取值保持一致, JSON 输出中使用
+const (
+	ChangeModify ChangeType = iota
+	ChangeAdd
+	ChangeDelete
+)
+
+const (
+	// aufs 用 .wh.<name> 表示删除了下层的 name
+	WhiteoutPrefix = ".wh."
+	// .wh..wh. 开头的是 aufs 自己的元数据, 例如 .wh..wh.aufs, .wh..wh.plnk
+	WhiteoutMetaPrefix = WhiteoutPrefix + WhiteoutPrefix
+	// 目录中有这个文件表示目录是 opaque 的, 下层同名目录的内容全部被隐藏
+	WhiteoutOpaqueDir = WhiteoutMetaPrefix + ".opq"
+
+	// overlay 用设备号为 0/0 的字符设备表示删除, 用这个 xattr 表示 opaque 目录
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+)
+
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeAdd:
+		return "A"
+	case ChangeDelete:
+		return "D"
+	}
+	return "C"
+}
+
+// 可写层相对镜像的一处修改, Path 为容器内的绝对路径
+type Change struct {
+	Path string     `json:"Path"`
+	Kind ChangeType `json:"Kind"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Kind, c.Path)
+}
+
+// 比较可写层 layer 和它下面的只读层 parents, parents 从上到下排列
+// 可写层中的文件在下层存在时为修改, 不存在时为新增, whiteout 为删除
+func Changes(layer string, parents []string) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := info.Name()
+		containerPath := "/" + rel
+
+		if strings.HasPrefix(name, WhiteoutMetaPrefix) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, WhiteoutPrefix) {
+			deleted := filepath.Join(filepath.Dir(containerPath), strings.TrimPrefix(name, WhiteoutPrefix))
+			if existsInLayers(parents, deleted) {
+				changes = append(changes, Change{Path: deleted, Kind: ChangeDelete})
+			}
+			return nil
+		}
+		if isOverlayWhiteout(info) {
+			if existsInLayers(parents, containerPath) {
+				changes = append(changes, Change{Path: containerPath, Kind: ChangeDelete})
+			}
+			return nil
+		}
+
+		kind := ChangeAdd
+		if existsInLayers(parents, containerPath) {
+			kind = ChangeModify
+		}
+		changes = append(changes, Change{Path: containerPath, Kind: kind})
+
+		// opaque 目录中没有出现的下层文件都被删除了
+		if info.IsDir() && kind == ChangeModify && isOpaqueDir(path) {
+			changes = append(changes, hiddenByOpaque(path, containerPath, parents)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// 从上到下查找, 遇到 whiteout 说明在更上层已经被删除
+func existsInLayers(layers []string, containerPath string) bool {
+	for _, layer := range layers {
+		path := filepath.Join(layer, containerPath)
+		if info, err := os.Lstat(path); err == nil {
+			return !isOverlayWhiteout(info)
+		}
+		whiteout := filepath.Join(filepath.Dir(path), WhiteoutPrefix+filepath.Base(path))
+		if _, err := os.Lstat(whiteout); err == nil {
+			return false
+		}
+	}
+	return false
+}
+
+func isOverlayWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+func isOpaqueDir(path string) bool {
+	if _, err := os.Lstat(filepath.Join(path, WhiteoutOpaqueDir)); err == nil {
+		return true
+	}
+	return isOverlayOpaque(path)
+}
+
+// opaque 目录 dir 隐藏掉的下层文件, 只报告第一级, 和删除一个目录时一样
+func hiddenByOpaque(dir, containerPath string, parents []string) []Change {
+	var changes []Change
+	seen := map[string]bool{}
+	for _, parent := range parents {
+		entries, err := os.ReadDir(filepath.Join(parent, containerPath))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if seen[name] || strings.HasPrefix(name, WhiteoutPrefix) {
+				continue
+			}
+			seen[name] = true
+			if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+				continue
+			}
+			changes = append(changes, Change{Path: filepath.Join(containerPath, name), Kind: ChangeDelete})
+		}
+	}
+	return changes
+}
+
+// 比较完整的根文件系统 rootfs 和它复制自的镜像层 layers, layers 从上到下排列, 使用 .wh. 格式的 whiteout
+// 用于 vfs 这种没有单独可写层的驱动, 元数据或内容不同的文件为修改, 修改过的文件所在的目录也报告为修改
+func ChangesDirs(rootfs string, layers []string) ([]Change, error) {
+	found := map[string]ChangeType{}
+	err := filepath.Walk(rootfs, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		containerPath := "/" + rel
+		lower := lookupInLayers(layers, containerPath)
+		if lower == nil {
+			found[containerPath] = ChangeAdd
+		} else if !sameFile(lower, info) {
+			found[containerPath] = ChangeModify
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 镜像中可见但 rootfs 中没有的文件被删除了, 目录被删除时只报告目录本身
+	for _, layer := range layers {
+		err := filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(layer, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			if strings.HasPrefix(info.Name(), WhiteoutPrefix) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			containerPath := "/" + rel
+			if _, err := os.Lstat(filepath.Join(rootfs, rel)); err == nil {
+				return nil
+			}
+			if lookupInLayers(layers, containerPath) != nil {
+				found[containerPath] = ChangeDelete
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 和联合文件系统的可写层一样, 修改过的路径的上级目录也算修改
+	for containerPath := range found {
+		for dir := filepath.Dir(containerPath); dir != "/"; dir = filepath.Dir(dir) {
+			if _, ok := found[dir]; !ok {
+				found[dir] = ChangeModify
+			}
+		}
+	}
+
+	changes := make([]Change, 0, len(found))
+	for containerPath, kind := range found {
+		changes = append(changes, Change{Path: containerPath, Kind: kind})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// 从上到下查找 containerPath 在镜像中可见的文件
+// 遇到 whiteout, 或者上级目录在某一层是 opaque 的, 更下层的文件都不可见
+func lookupInLayers(layers []string, containerPath string) os.FileInfo {
+	for _, layer := range layers {
+		path := filepath.Join(layer, containerPath)
+		if info, err := os.Lstat(path); err == nil {
+			return info
+		}
+		for p := containerPath; p != "/"; p = filepath.Dir(p) {
+			whiteout := filepath.Join(layer, filepath.Dir(p), WhiteoutPrefix+filepath.Base(p))
+			if _, err := os.Lstat(whiteout); err == nil {
+				return nil
+			}
+			if p != containerPath {
+				if _, err := os.Lstat(filepath.Join(layer, p, WhiteoutOpaqueDir)); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 复制时保留了权限, 属主和修改时间, 这些都相同时认为文件没有修改
+// 目录的修改时间在增删子文件时也会变化, 不作为判断依据
+func sameFile(a, b os.FileInfo) bool {
+	if a.Mode() != b.Mode() {
+		return false
+	}
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	sb, ok2 := b.Sys().(*syscall.Stat_t)
+	if !ok || !ok2 {
+		return false
+	}
+	if sa.Uid != sb.Uid || sa.Gid != sb.Gid || sa.Rdev != sb.Rdev {
+		return false
+	}
+	if a.IsDir() {
+		return true
+	}
+	return a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
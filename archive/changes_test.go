@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files ...string) {
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if f[len(f)-1] == '/' {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChangesAufs(t *testing.T) {
+	lower := t.TempDir()
+	upper := t.TempDir()
+	writeFiles(t, lower, "etc/passwd", "etc/hosts", "var/log/old", "opt/a", "opt/b")
+	writeFiles(t, upper,
+		"etc/passwd",                // 修改
+		"etc/new",                   // 新增
+		"etc/.wh.hosts",             // 删除
+		"var/.wh.log",               // 删除整个目录
+		"tmp/.wh.notinlower",        // 下层没有的文件不算删除
+		".wh..wh.plnk/123",          // aufs 元数据
+		"opt/.wh..wh..opq", "opt/c", // opaque 目录
+	)
+
+	changes, err := Changes(upper, []string{lower})
+	if err != nil {
+		t.Fatalf("changes error %v", err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	expect := []string{
+		"C /etc", "D /etc/hosts", "A /etc/new", "C /etc/passwd",
+		"C /opt", "D /opt/a", "D /opt/b", "A /opt/c",
+		"A /tmp",
+		"C /var", "D /var/log",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+}
+
+func TestChangesOverlayWhiteout(t *testing.T) {
+	lower := t.TempDir()
+	upper := t.TempDir()
+	writeFiles(t, lower, "etc/hosts")
+	writeFiles(t, upper, "etc/")
+	if err := syscall.Mknod(filepath.Join(upper, "etc/hosts"), syscall.S_IFCHR, 0); err != nil {
+		t.Skipf("mknod whiteout not permitted: %v", err)
+	}
+	changes, err := Changes(upper, []string{lower})
+	if err != nil {
+		t.Fatalf("changes error %v", err)
+	}
+	expect := []Change{{Path: "/etc", Kind: ChangeModify}, {Path: "/etc/hosts", Kind: ChangeDelete}}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expect %v, got %v", expect, changes)
+	}
+}
+
+func TestTarLayerWhiteouts(t *testing.T) {
+	layer := t.TempDir()
+	writeFiles(t, layer, "etc/passwd", "etc/.wh.hosts", ".wh..wh.plnk/123", ".wh..wh.aufs", "opt/.wh..wh..opq")
+	if err := os.Link(filepath.Join(layer, "etc/passwd"), filepath.Join(layer, "etc/passwd2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mknod(filepath.Join(layer, "etc/shadow"), syscall.S_IFCHR, 0); err != nil {
+		t.Logf("mknod whiteout not permitted: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := TarLayer(layer, &buf); err != nil {
+		t.Fatalf("tar layer error %v", err)
+	}
+	entries := map[string]byte{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar error %v", err)
+		}
+		entries[hdr.Name] = hdr.Typeflag
+	}
+	for _, name := range []string{"etc/", "etc/passwd", "etc/.wh.hosts", "opt/.wh..wh..opq"} {
+		if _, ok := entries[name]; !ok {
+			t.Fatalf("missing %s in %v", name, entries)
+		}
+	}
+	if entries["etc/passwd2"] != tar.TypeLink {
+		t.Fatalf("expect etc/passwd2 to be a hard link, got %v", entries)
+	}
+	for name := range entries {
+		if strings.HasPrefix(name, ".wh..wh.plnk") || name == ".wh..wh.aufs" {
+			t.Fatalf("aufs metadata %s should not be packed", name)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(layer, "etc/shadow")); err == nil {
+		if entries["etc/.wh.shadow"] != tar.TypeReg || entries["etc/shadow"] != 0 {
+			t.Fatalf("overlay whiteout not converted: %v", entries)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const paxXattrPrefix = "SCHILY.xattr."
+
+type UntarOptions struct {
+	// 把 .wh. 文件转换为 overlay 使用的字符设备和 opaque xattr, aufs 的元数据不解压
+	OverlayWhiteouts bool
+}
+
+// 归档中的路径不能是绝对路径, 也不能通过 .. 跳出归档
+func CleanPath(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(name, "./"))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid path %s in archive", name)
+	}
+	return cleaned, nil
+}
+
+// 把镜像层 r 解压到 dir, r 可以是 gzip 或 zstd 压缩的
+// 保留属主, 权限, xattr, 硬链接和设备文件, 拒绝跳出 dir 的路径, 包括经过归档中符号链接的路径
+func Untar(r io.Reader, dir string, opts *UntarOptions) error {
+	if opts == nil {
+		opts = &UntarOptions{}
+	}
+	stream, err := DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// 目录的时间在其中的文件都解压完之后再设置
+	var dirs []*tar.Header
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name, err := CleanPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if opts.OverlayWhiteouts {
+			done, err := convertWhiteout(dir, hdr)
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+		}
+		if err := extractEntry(dir, hdr, tr); err != nil {
+			return fmt.Errorf("extract %s error %v", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+	for _, hdr := range dirs {
+		if err := setTimes(filepath.Join(dir, hdr.Name), hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 解压路径 name 的上级目录中不能有符号链接, 否则解压时会写到符号链接指向的位置
+// name 是相对于 dir 的路径, 以 / 分隔
+func CheckParents(dir, name string) error {
+	parent := dir
+	for _, part := range strings.Split(path.Dir(name), "/") {
+		if part == "." {
+			break
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid path %s in archive: %s is a symbolic link", name, strings.TrimPrefix(parent, dir+"/"))
+		}
+	}
+	return nil
+}
+
+// 处理 .wh. 文件, 返回 true 表示这个条目已经处理, 不需要再解压
+func convertWhiteout(dir string, hdr *tar.Header) (bool, error) {
+	parent, base := path.Split(hdr.Name)
+	if strings.Contains("/"+hdr.Name, "/"+WhiteoutMetaPrefix) && base != WhiteoutOpaqueDir {
+		// 其他 aufs 元数据, overlay 不需要
+		return true, nil
+	}
+	if !strings.HasPrefix(base, WhiteoutPrefix) {
+		return false, nil
+	}
+	if err := CheckParents(dir, hdr.Name); err != nil {
+		return true, err
+	}
+	parentDir := filepath.Join(dir, parent)
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return true, err
+	}
+	if base == WhiteoutOpaqueDir {
+		return true, syscall.Setxattr(parentDir, overlayOpaqueXattr, []byte("y"), 0)
+	}
+	target := filepath.Join(parentDir, strings.TrimPrefix(base, WhiteoutPrefix))
+	if err := os.RemoveAll(target); err != nil {
+		return true, err
+	}
+	if err := syscall.Mknod(target, syscall.S_IFCHR, 0); err != nil {
+		return true, err
+	}
+	return true, os.Lchown(target, hdr.Uid, hdr.Gid)
+}
+
+func extractEntry(dir string, hdr *tar.Header, r io.Reader) error {
+	if hdr.Name == "." && hdr.Typeflag != tar.TypeDir {
+		return fmt.Errorf("invalid entry type %c", hdr.Typeflag)
+	}
+	if err := CheckParents(dir, hdr.Name); err != nil {
+		return err
+	}
+	target := filepath.Join(dir, hdr.Name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// 已经存在的文件被后面的条目覆盖, 已经存在的目录保留其中的内容
+	if info, err := os.Lstat(target); err == nil {
+		if !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// 符号链接只创建, 解压时不会跟随, 指向哪里都可以
+		return applyMetadata(target, hdr, os.Symlink(hdr.Linkname, target))
+	case tar.TypeLink:
+		linkname, err := CleanPath(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := CheckParents(dir, linkname); err != nil {
+			return err
+		}
+		// 硬链接和原文件共享元数据, 不需要再设置
+		return os.Link(filepath.Join(dir, linkname), target)
+	case tar.TypeChar:
+		return applyMetadata(target, hdr, unix.Mknod(target, unix.S_IFCHR|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))))
+	case tar.TypeBlock:
+		return applyMetadata(target, hdr, unix.Mknod(target, unix.S_IFBLK|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor)))))
+	case tar.TypeFifo:
+		return applyMetadata(target, hdr, unix.Mkfifo(target, mode))
+	default:
+		// pax 全局头等其他类型忽略
+		return nil
+	}
+	return applyMetadata(target, hdr, nil)
+}
+
+// 文件创建成功后设置属主, xattr, 权限和时间
+// 修改属主会清除 setuid 位, 所以在修改属主之后再设置权限
+func applyMetadata(target string, hdr *tar.Header, err error) error {
+	if err != nil {
+		return err
+	}
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, paxXattrPrefix) {
+			continue
+		}
+		err := unix.Lsetxattr(target, strings.TrimPrefix(key, paxXattrPrefix), []byte(value), 0)
+		// 文件系统不支持 xattr 时忽略
+		if err != nil && err != unix.ENOTSUP {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return setTimes(target, hdr)
+	}
+	if err := unix.Chmod(target, uint32(hdr.Mode&07777)); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return setTimes(target, hdr)
+}
+
+func setTimes(target string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	times := []unix.Timespec{timespec(atime), timespec(hdr.ModTime)}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, target, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func timespec(t time.Time) unix.Timespec {
+	if t.IsZero() {
+		return unix.Timespec{Nsec: unix.UTIME_OMIT}
+	}
+	return unix.NsecToTimespec(t.UnixNano())
+}
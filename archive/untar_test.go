@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func buildTar(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestUntarRejectsTraversal(t *testing.T) {
+	cases := map[string][]*tar.Header{
+		"dotdot":   {{Typeflag: tar.TypeReg, Name: "../escape", Mode: 0644}},
+		"absolute": {{Typeflag: tar.TypeReg, Name: "/etc/../../escape", Mode: 0644}},
+		"symlink parent": {
+			{Typeflag: tar.TypeSymlink, Name: "link", Linkname: "/tmp"},
+			{Typeflag: tar.TypeReg, Name: "link/escape", Mode: 0644},
+		},
+		"hardlink": {{Typeflag: tar.TypeLink, Name: "passwd", Linkname: "../../etc/passwd"}},
+		"hardlink through symlink": {
+			{Typeflag: tar.TypeSymlink, Name: "etc", Linkname: "/etc"},
+			{Typeflag: tar.TypeLink, Name: "passwd", Linkname: "etc/passwd"},
+		},
+	}
+	for name, headers := range cases {
+		dir := t.TempDir()
+		if err := Untar(buildTar(t, headers...), dir, nil); err == nil {
+			t.Fatalf("%s: expect error", name)
+		}
+	}
+}
+
+func TestUntar(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, "etc/passwd", "bin/sh", "etc/.wh.hosts", "opt/.wh..wh..opq")
+	os.Chmod(filepath.Join(src, "bin/sh"), 0755|os.ModeSetuid)
+	os.Link(filepath.Join(src, "etc/passwd"), filepath.Join(src, "etc/passwd-"))
+	os.Symlink("/etc/passwd", filepath.Join(src, "bin/link"))
+	var buf bytes.Buffer
+	if err := TarLayer(src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// 压缩后的镜像层也能解压
+	var compressed bytes.Buffer
+	zw, _ := zstd.NewWriter(&compressed)
+	zw.Write(buf.Bytes())
+	zw.Close()
+
+	dir := t.TempDir()
+	if err := Untar(&compressed, dir, nil); err != nil {
+		t.Fatalf("untar error %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "bin/sh"))
+	if err != nil || info.Mode()&os.ModeSetuid == 0 {
+		t.Fatalf("setuid bit lost: %v %v", info, err)
+	}
+	st1, _ := os.Stat(filepath.Join(dir, "etc/passwd"))
+	st2, _ := os.Stat(filepath.Join(dir, "etc/passwd-"))
+	if !os.SameFile(st1, st2) {
+		t.Fatalf("hard link not preserved")
+	}
+	if link, err := os.Readlink(filepath.Join(dir, "bin/link")); err != nil || link != "/etc/passwd" {
+		t.Fatalf("symlink not preserved: %s %v", link, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "etc/.wh.hosts")); err != nil {
+		t.Fatalf("whiteout should be kept without overlay conversion: %v", err)
+	}
+
+	dir = t.TempDir()
+	if err := Untar(bytes.NewReader(buf.Bytes()), dir, &UntarOptions{OverlayWhiteouts: true}); err != nil {
+		t.Skipf("overlay whiteouts not permitted: %v", err)
+	}
+	info, err = os.Lstat(filepath.Join(dir, "etc/hosts"))
+	if err != nil || !isOverlayWhiteout(info) {
+		t.Fatalf("whiteout not converted: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "opt", WhiteoutOpaqueDir)); !os.IsNotExist(err) {
+		t.Fatalf("opaque marker should not be extracted")
+	}
+	if !isOverlayOpaque(filepath.Join(dir, "opt")) {
+		t.Fatalf("opaque dir not converted")
+	}
+	var stat syscall.Stat_t
+	syscall.Stat(filepath.Join(dir, "etc/hosts"), &stat)
+	if stat.Rdev != 0 {
+		t.Fatalf("whiteout device should be 0/0")
+	}
+}
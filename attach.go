@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mydocker/container"
+	"mydocker/logger"
+	"mydocker/term"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// attach 连接上输出帧的头部: 1 字节流类型, 3 字节填充, 4 字节大端长度
+	streamStdout   = 1
+	streamStderr   = 2
+	frameHeaderLen = 8
+
+	// 默认的 detach 按键 ctrl-p ctrl-q
+	detachKeyFirst  = 0x10
+	detachKeySecond = 0x11
+
+	// 向 attach 客户端写数据的超时时间, 超时的客户端会被断开
+	attachWriteTimeout = 5 * time.Second
+	// 每个 attach 客户端最多缓存的输出帧数, 跟不上容器输出的客户端会被断开, 不能拖慢容器输出
+	attachClientQueueLen = 128
+)
+
+// monitor 进程中容器的标准输入输出, 在容器多次重启之间保持不变
+// 输出按行交给日志驱动写入 container.log, 同时转发给所有 attach 的客户端, 客户端的输入写入容器的标准输入
+type containerStreams struct {
+	sync.Mutex
+	logger    logger.Logger
+	listener  net.Listener
+	clients   map[net.Conn]*attachClient
+	openStdin bool
+	stdin     io.WriteCloser
+	copiers   sync.WaitGroup
+}
+
+type attachClient struct {
+	frames chan []byte   // 待写出的输出帧
+	done   chan struct{} // 写出帧的 goroutine 退出后关闭
+}
+
+func newContainerStreams(info *container.ContainerInfo) (*containerStreams, error) {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, info.Id)
+	stdLogFilePath := dirURL + container.ContainerLogFile
+	containerLogger, err := logger.New(info.LogConfig.Type, logger.Info{
+		ContainerID:   info.Id,
+		ContainerName: info.Name,
+		LogPath:       stdLogFilePath,
+		Config:        info.LogConfig.Config,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create logger %s error %v", stdLogFilePath, err)
+	}
+
+	socketPath := dirURL + container.AttachSocketName
+	_ = os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		containerLogger.Close()
+		return nil, fmt.Errorf("listen %s error %v", socketPath, err)
+	}
+
+	streams := &containerStreams{
+		logger:    containerLogger,
+		listener:  listener,
+		clients:   map[net.Conn]*attachClient{},
+		openStdin: info.OpenStdin,
+	}
+	go streams.serve()
+	return streams, nil
+}
+
+func (s *containerStreams) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		client := &attachClient{frames: make(chan []byte, attachClientQueueLen), done: make(chan struct{})}
+		s.Lock()
+		s.clients[conn] = client
+		s.Unlock()
+		go s.writeClient(conn, client)
+		go s.readClientInput(conn)
+	}
+}
+
+// 每个客户端由单独的 goroutine 写出, 慢的客户端不会阻塞容器的输出和其他客户端
+// frames 关闭后写完剩下的帧再断开连接
+func (s *containerStreams) writeClient(conn net.Conn, client *attachClient) {
+	defer close(client.done)
+	defer conn.Close()
+	for frame := range client.frames {
+		_ = conn.SetWriteDeadline(time.Now().Add(attachWriteTimeout))
+		if _, err := conn.Write(frame); err != nil {
+			s.removeClient(conn)
+			return
+		}
+	}
+}
+
+// 把客户端的输入转发到容器的标准输入, 没有 -i 时直接丢弃
+func (s *containerStreams) readClientInput(conn net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			s.Lock()
+			stdin := s.stdin
+			s.Unlock()
+			if stdin != nil {
+				_, _ = stdin.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			s.removeClient(conn)
+			return
+		}
+	}
+}
+
+func (s *containerStreams) removeClient(conn net.Conn) {
+	s.Lock()
+	s.dropClient(conn)
+	s.Unlock()
+	conn.Close()
+}
+
+// 调用时需要持有锁
+func (s *containerStreams) dropClient(conn net.Conn) {
+	if client, ok := s.clients[conn]; ok {
+		delete(s.clients, conn)
+		close(client.frames)
+	}
+}
+
+// 为新启动的容器进程创建管道, 需要在 cmd.Start 之前调用
+// 返回的函数在 cmd.Start 之后调用, 关闭父进程中属于子进程的那一端
+func (s *containerStreams) attachProcess(cmd *exec.Cmd) (func(), error) {
+	stdoutRead, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrRead, stderrWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = stdoutWrite
+	cmd.Stderr = stderrWrite
+	childEnds := []*os.File{stdoutWrite, stderrWrite}
+
+	if s.openStdin {
+		stdinRead, stdinWrite, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin = stdinRead
+		childEnds = append(childEnds, stdinRead)
+		s.Lock()
+		s.stdin = stdinWrite
+		s.Unlock()
+	}
+
+	s.copiers.Add(2)
+	go s.copyOutput(streamStdout, stdoutRead)
+	go s.copyOutput(streamStderr, stderrRead)
+	return func() {
+		for _, f := range childEnds {
+			f.Close()
+		}
+	}, nil
+}
+
+func (s *containerStreams) copyOutput(stream byte, r *os.File) {
+	defer s.copiers.Done()
+	defer r.Close()
+	source := "stdout"
+	if stream == streamStderr {
+		source = "stderr"
+	}
+	lines := logger.NewLineWriter(s.logger, source)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.write(stream, lines, buf[:n])
+		}
+		if err != nil {
+			// 最后一行可能没有换行符
+			s.Lock()
+			if err := lines.Close(); err != nil {
+				logrus.Errorf("Write container log error %v", err)
+			}
+			s.Unlock()
+			return
+		}
+	}
+}
+
+func (s *containerStreams) write(stream byte, lines *logger.LineWriter, p []byte) {
+	s.Lock()
+	defer s.Unlock()
+	if _, err := lines.Write(p); err != nil {
+		logrus.Errorf("Write container log error %v", err)
+	}
+
+	frame := make([]byte, frameHeaderLen+len(p))
+	frame[0] = stream
+	binary.BigEndian.PutUint32(frame[4:frameHeaderLen], uint32(len(p)))
+	copy(frame[frameHeaderLen:], p)
+	// 只把帧放进客户端的队列, 不在持有锁时写连接
+	for conn, client := range s.clients {
+		select {
+		case client.frames <- frame:
+		default:
+			s.dropClient(conn)
+			conn.Close()
+		}
+	}
+}
+
+// 容器进程退出后等待输出全部写完, 关闭标准输入并断开所有客户端
+func (s *containerStreams) detachProcess() {
+	s.copiers.Wait()
+	s.Lock()
+	if s.stdin != nil {
+		s.stdin.Close()
+		s.stdin = nil
+	}
+	var clients []*attachClient
+	for conn, client := range s.clients {
+		clients = append(clients, client)
+		s.dropClient(conn)
+	}
+	s.Unlock()
+	// 客户端写完队列中剩下的输出后断开
+	for _, client := range clients {
+		<-client.done
+	}
+}
+
+func (s *containerStreams) Close() {
+	s.listener.Close()
+	s.logger.Close()
+}
+
+// 连接到运行中的容器的标准输入输出, 容器退出时返回它的退出码
+// 按下 ctrl-p ctrl-q 会断开连接, 容器继续运行
+func attachContainer(containerName string) (int, error) {
+	info, err := resolveContainer(containerName)
+	if err != nil {
+		return 0, err
+	}
+	if info.TTY {
+		return 0, fmt.Errorf("container %s is running in the foreground", containerName)
+	}
+	if info.Status != container.RUNNING {
+		return 0, fmt.Errorf("You cannot attach to a stopped container, start it first")
+	}
+	socketPath := fmt.Sprintf(container.DefaultInfoLocation, info.Id) + container.AttachSocketName
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return 0, fmt.Errorf("connect to container %s error %v", containerName, err)
+	}
+	defer conn.Close()
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		readFrames(conn)
+	}()
+
+	detached := make(chan struct{})
+	if info.OpenStdin {
+		if term.IsTerminal(os.Stdin.Fd()) {
+			if oldState, err := term.SetInputUnbuffered(os.Stdin.Fd()); err == nil {
+				defer term.Restore(os.Stdin.Fd(), oldState)
+			}
+		}
+		go func() {
+			if copyInputUntilDetach(conn, os.Stdin) {
+				close(detached)
+			}
+		}()
+	}
+
+	select {
+	case <-detached:
+		fmt.Fprintln(os.Stderr, "read escape sequence")
+		return 0, nil
+	case <-outputDone:
+	}
+	// monitor 在断开连接之前已经记录了退出码
+	if info, err = getContainerInfoById(info.Id); err != nil {
+		return 0, nil
+	}
+	return info.State.ExitCode, nil
+}
+
+// 读取 monitor 发来的输出帧, 按流类型写到标准输出或标准错误
+func readFrames(r io.Reader) {
+	br := bufio.NewReader(r)
+	header := make([]byte, frameHeaderLen)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return
+		}
+		size := binary.BigEndian.Uint32(header[4:])
+		out := os.Stdout
+		if header[0] == streamStderr {
+			out = os.Stderr
+		}
+		if _, err := io.CopyN(out, br, int64(size)); err != nil {
+			return
+		}
+	}
+}
+
+// 把输入转发给容器, 遇到 detach 按键序列时返回 true
+func copyInputUntilDetach(w io.Writer, r io.Reader) bool {
+	buf := make([]byte, 1024)
+	pending := false
+	for {
+		n, err := r.Read(buf)
+		out := make([]byte, 0, n+1)
+		for _, b := range buf[:n] {
+			if pending {
+				pending = false
+				if b == detachKeySecond {
+					return true
+				}
+				out = append(out, detachKeyFirst)
+			}
+			if b == detachKeyFirst {
+				pending = true
+				continue
+			}
+			out = append(out, b)
+		}
+		if len(out) > 0 {
+			if _, werr := w.Write(out); werr != nil {
+				return false
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package cgroups
 
 import (
+	"bytes"
 	"mydocker/cgroups/subsystems"
+	"os"
+	"path"
 
 	"github.com/sirupsen/logrus"
 )
@@ -43,3 +46,26 @@ func (c *CgroupManager) Destroy() error {
 	}
 	return nil
 }
+
+// 容器是否因为超出内存限制被 OOM killer 杀死
+func (c *CgroupManager) OOMKilled() bool {
+	return subsystems.OOMKillCount(c.Path) > 0
+}
+
+// 各个 subsystem 中这个 cgroup 的 cgroup.procs 文件, 用于把 exec 的进程加入容器的 cgroup
+// 容器进程没能加入的 cgroup 不返回, 例如没有设置 cpuset.mems 的 cpuset, exec 的进程也无法加入
+func (c *CgroupManager) ProcsFiles() []string {
+	var files []string
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		cgroupPath, err := subsystems.GetCgroupPath(subSysIns.Name(), c.Path, false)
+		if err != nil {
+			continue
+		}
+		procsFile := path.Join(cgroupPath, "cgroup.procs")
+		if content, err := os.ReadFile(procsFile); err != nil || len(bytes.TrimSpace(content)) == 0 {
+			continue
+		}
+		files = append(files, procsFile)
+	}
+	return files
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type MemorySubSystem struct {
@@ -49,6 +50,26 @@ func (s *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 	}
 }
 
+// 读取 memory.oom_control 中的 oom_kill 计数, 读取失败时返回 0
+func OOMKillCount(cgroupPath string) int {
+	subsysCgroupPath, err := GetCgroupPath("memory", cgroupPath, false)
+	if err != nil {
+		return 0
+	}
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "memory.oom_control"))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, _ := strconv.Atoi(fields[1])
+			return count
+		}
+	}
+	return 0
+}
+
 func (s *MemorySubSystem) Name() string {
 	return "memory"
 }
\ No newline at end of file
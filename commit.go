@@ -2,19 +2,62 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"mydocker/container"
-	"os/exec"
-
-	"github.com/sirupsen/logrus"
+	"mydocker/image"
+	"os"
+	"time"
 )
 
-func commitContainer(containerName, imageName string) {
-	mntURL := fmt.Sprintf(container.MntUrl, containerName)
-	mntURL += "/"
+type commitOptions struct {
+	Author  string
+	Message string
+	Changes []string // --change 传入的 Dockerfile 指令
+}
 
-	imageTar := container.RootUrl + "/" + imageName + ".tar"
+// 把容器的可写层打包成新的镜像层, 叠加在容器所用镜像的各层之上, 生成新的镜像
+// ref 为 name[:tag], 已经存在的 tag 会指向新镜像, 为空时生成没有 tag 的镜像
+func commitContainer(containerName, ref string, opts commitOptions) error {
+	containerInfo, err := resolveContainer(containerName)
+	if err != nil {
+		return err
+	}
+	var refs []string
+	if ref != "" {
+		if _, err := image.NormalizeReference(ref); err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+	}
+	parentID, parent, err := image.LoadConfig(containerInfo.ImageRef())
+	if err != nil {
+		return fmt.Errorf("load config of image %s error %v", containerInfo.Image, err)
+	}
+	config := *parent
+	config.Env = append([]string(nil), parent.Env...)
+	config.Layers = append([]string(nil), parent.Layers...)
+	if err := image.ApplyChanges(&config, opts.Changes); err != nil {
+		return err
+	}
 
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
-		logrus.Errorf("Tar folder %s error %v", mntURL, err)
+	driver, err := container.StorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		return err
+	}
+	imageLayers, err := image.LayerDirs(parentID, driver)
+	if err != nil {
+		return fmt.Errorf("get layers of image %s error %v", containerInfo.Image, err)
+	}
+	config.Author = opts.Author
+	config.Comment = opts.Message
+	config.Created = time.Now().UTC()
+	config.Parent = parentID
+	id, err := image.CreateImage(&config, []image.LayerWriter{func(w io.Writer) error {
+		return driver.Diff(containerInfo.Id, imageLayers, w)
+	}}, refs...)
+	if err != nil {
+		return fmt.Errorf("create image from container %s error %v", containerName, err)
 	}
+	fmt.Fprintln(os.Stdout, id)
+	return nil
 }
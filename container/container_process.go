@@ -2,42 +2,126 @@ package container
 
 import (
 	"fmt"
+	"mydocker/cgroups/subsystems"
+	"mydocker/image"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
-
-	"github.com/sirupsen/logrus"
+	"time"
 )
 
 var (
 	RUNNING             string = "running"
+	RESTARTING          string = "restarting"
 	STOP                string = "stopped"
 	Exit                string = "exited"
-	DefaultInfoLocation string = "/var/run/mydocker/%s/"
+	DefaultInfoLocation string = "/var/run/mydocker/containers/%s/"
 	ConfigName          string = "config.json"
+	ConfigLockName      string = "config.lock"
+	ContainersLockFile  string = "/var/run/mydocker/containers.lock"
 	ContainerLogFile    string = "container.log"
-	RootUrl             string = "/root"
+	AttachSocketName    string = "attach.sock"
+	RootUrl             string = image.RootUrl
 	MntUrl              string = "/root/mnt/%s"
-	WriteLayerUrl       string = "/root/writeLayer/%s"
 )
 
 type ContainerInfo struct {
-	Pid         string   `json:"pid"`         // 容器的 init 进程在宿主机上的 PID
-	Id          string   `json:"id"`          // 容器 Id
-	Name        string   `json:"name"`        // 容器名
-	Command     string   `json:"command"`     // 容器内 init 运行命令
-	CreatedTime string   `json:"createTime"`  // 创建时间
-	Status      string   `json:"status"`      // 容器的状态
-	Volume      string   `json:"volume"`      // 容器的数据卷
-	PortMapping []string `json:"portmapping"` // 端口映射
+	Pid             string                     `json:"pid"`             // 容器的 init 进程在宿主机上的 PID
+	Id              string                     `json:"id"`              // 容器 Id
+	Name            string                     `json:"name"`            // 容器名
+	Command         string                     `json:"command"`         // 容器内 init 运行命令
+	CmdArray        []string                   `json:"cmdArray"`        // 拆分后的命令, 包括 Entrypoint, 重启时使用
+	Entrypoint      []string                   `json:"entrypoint"`      // 镜像或 --entrypoint 指定的命令前缀
+	CreatedTime     string                     `json:"createTime"`      // 创建时间
+	Status          string                     `json:"status"`          // 容器的状态
+	Volume          string                     `json:"volume"`          // 容器的数据卷
+	PortMapping     []string                   `json:"portmapping"`     // 端口映射
+	Image           string                     `json:"image"`           // 镜像名
+	ImageID         string                     `json:"imageId"`         // 创建容器时镜像名对应的镜像 ID
+	Env             []string                   `json:"env"`             // 容器的全部环境变量: 镜像配置, --env-file, -e 依次覆盖
+	WorkingDir      string                     `json:"workingDir"`      // 容器进程的工作目录, 为空时为 /
+	User            string                     `json:"user"`            // 运行容器进程的用户, user[:group] 或 uid[:gid], 为空时为 root
+	StopSignal      string                     `json:"stopSignal"`      // mydocker stop 发送的信号, 为空时为 SIGTERM
+	ExposedPorts    []string                   `json:"exposedPorts"`    // 镜像声明的端口, 例如 80/tcp
+	Network         string                     `json:"network"`         // 容器加入的网络
+	ResourceConfig  *subsystems.ResourceConfig `json:"resourceConfig"`  // cgroup 资源限制
+	TTY             bool                       `json:"tty"`             // 是否前台 -ti 运行
+	OpenStdin       bool                       `json:"openStdin"`       // -i 参数, 后台运行时保持标准输入打开, 通过 attach 写入
+	RestartPolicy   RestartPolicy              `json:"restartPolicy"`   // 重启策略
+	RestartCount    int                        `json:"restartCount"`    // 已经重启的次数
+	MonitorPid      int                        `json:"monitorPid"`      // 负责启动和重启容器的 monitor 进程 PID
+	ManuallyStopped bool                       `json:"manuallyStopped"` // 是否被 mydocker stop 停止, 停止后不再重启
+	Healthcheck     *HealthConfig              `json:"healthcheck"`     // 健康检查配置, 为空表示不检查
+	Health          *Health                    `json:"health"`          // 健康检查的状态和最近的结果
+	Mounts          []Mount                    `json:"mounts"`          // 挂载到容器内的数据卷
+	State           State                      `json:"state"`           // 最近一次运行的状态
+	NetworkSettings NetworkSettings            `json:"networkSettings"` // 容器在网络中的端点信息
+	LogConfig       LogConfig                  `json:"logConfig"`       // 容器输出的日志驱动
+	ExecSessions    []ExecSession              `json:"execSessions"`    // 正在运行的 exec 会话
+	StorageDriver   string                     `json:"storageDriver"`   // 容器根文件系统的存储驱动, 为空的旧容器使用 aufs
+}
+
+// 容器使用的镜像, 旧版本的容器没有记录镜像 ID, 按镜像名查找
+func (c *ContainerInfo) ImageRef() string {
+	if c.ImageID != "" {
+		return c.ImageID
+	}
+	return c.Image
+}
+
+// 日志驱动配置, Type 为空的旧容器按 raw 格式读写
+type LogConfig struct {
+	Type   string            `json:"type"`
+	Config map[string]string `json:"config"` // --log-opt 传入的参数, 例如 max-size, max-file
+}
+
+// 数据卷挂载信息, 由 -v 参数解析得到
+type Mount struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// 容器最近一次运行的状态
+type State struct {
+	ExitCode   int       `json:"exitCode"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	OOMKilled  bool      `json:"oomKilled"`
+	Error      string    `json:"error"` // 启动失败时的错误信息
+}
+
+// 容器网络端点信息, 由 network.Connect 填写
+type NetworkSettings struct {
+	Network     string   `json:"network"`
+	EndpointID  string   `json:"endpointId"`
+	IPAddress   string   `json:"ipAddress"`
+	IPPrefixLen int      `json:"ipPrefixLen"`
+	Gateway     string   `json:"gateway"`
+	MacAddress  string   `json:"macAddress"`
+	Ports       []string `json:"ports"`
+}
+
+// 解析 -v hostPath:containerPath 参数
+func ParseVolumeMounts(volume string) []Mount {
+	volumeURLs := strings.Split(volume, ":")
+	if len(volumeURLs) != 2 || volumeURLs[0] == "" || volumeURLs[1] == "" {
+		return nil
+	}
+	return []Mount{{
+		Type:        "bind",
+		Source:      volumeURLs[0],
+		Destination: volumeURLs[1],
+	}}
 }
 
 // Parent 就是这个 golang 编写的程序
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+// 容器的根文件系统准备失败时返回错误, 不会启动 init 进程
+func NewParentProcess(containerId, storageDriver, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File, error) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
-		logrus.Errorf("New pipe error %v", err)
-		return nil, nil
+		return nil, nil, fmt.Errorf("new pipe error %v", err)
 	}
 	// fork 出来的子进程执行 initCommand
 	cmd := exec.Command("/proc/self/exe", "init")
@@ -45,31 +129,19 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
-	// -ti 参数
-	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(dirURL, 0622); err != nil {
-			logrus.Errorf("NewParentProcess mkdir %s error %v", dirURL, err)
-			return nil, nil
-		}
-		stdLogFilePath := dirURL + ContainerLogFile
-		stdLogFile, err := os.Create(stdLogFilePath)
-		if err != nil {
-			logrus.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
-			return nil, nil
-		}
-		cmd.Stdout = stdLogFile
-	}
+	// 标准输入输出由 monitor 进程接管
+	// -ti 时分配伪终端, 后台运行时写入日志并转发给 attach 的客户端
 
 	cmd.ExtraFiles = []*os.File{readPipe}
-	cmd.Env = append(os.Environ(), envSlice...)
-	NewWorkSpace(volume, imageName, containerName)
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
-	return cmd, writePipe
+	// 不继承宿主机的环境变量, 只使用合并好的容器环境变量
+	cmd.Env = envSlice
+	if err := NewWorkSpace(storageDriver, volume, imageName, containerId); err != nil {
+		readPipe.Close()
+		writePipe.Close()
+		return nil, nil, err
+	}
+	cmd.Dir = fmt.Sprintf(MntUrl, containerId)
+	return cmd, writePipe, nil
 }
 
 func NewPipe() (*os.File, *os.File, error) {
@@ -0,0 +1,71 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 读取 --env-file, 每行一个 KEY=VALUE, 忽略空行和 # 开头的注释
+// 只写 KEY 时使用宿主机上同名环境变量的值
+func ParseEnvFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimLeft(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key := strings.SplitN(line, "=", 2)[0]
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid environment variable %q in %s line %d", line, path, lineNum)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ExpandEnv(lines), nil
+}
+
+// 把只写了 KEY 的环境变量替换为宿主机上的 KEY=VALUE, 宿主机上没有时忽略
+func ExpandEnv(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if strings.Contains(value, "=") {
+			result = append(result, value)
+			continue
+		}
+		if v, ok := os.LookupEnv(value); ok {
+			result = append(result, value+"="+v)
+		}
+	}
+	return result
+}
+
+// 按顺序合并多组环境变量, 后面的同名变量覆盖前面的, 变量的位置保持第一次出现的位置
+func MergeEnv(envs ...[]string) []string {
+	var result []string
+	index := map[string]int{}
+	for _, env := range envs {
+		for _, kv := range env {
+			key := strings.SplitN(kv, "=", 2)[0]
+			if i, ok := index[key]; ok {
+				result[i] = kv
+				continue
+			}
+			index[key] = len(result)
+			result = append(result, kv)
+		}
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	content := "# comment\n\nFOO=bar\n  SPACED=a b\nEMPTY=\nFROM_HOST\nMISSING_ON_HOST\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FROM_HOST", "host")
+	os.Unsetenv("MISSING_ON_HOST")
+
+	env, err := ParseEnvFile(path)
+	if err != nil {
+		t.Fatalf("parse env file error %v", err)
+	}
+	expect := []string{"FOO=bar", "SPACED=a b", "EMPTY=", "FROM_HOST=host"}
+	if !reflect.DeepEqual(env, expect) {
+		t.Fatalf("expect %v, got %v", expect, env)
+	}
+
+	if err := os.WriteFile(path, []byte("BAD KEY=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseEnvFile(path); err == nil {
+		t.Fatalf("expect error for invalid key")
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	env := MergeEnv(
+		[]string{"PATH=/bin", "HOME=/root"},
+		[]string{"FOO=file", "HOME=/home"},
+		[]string{"FOO=flag"},
+	)
+	expect := []string{"PATH=/bin", "HOME=/home", "FOO=flag"}
+	if !reflect.DeepEqual(env, expect) {
+		t.Fatalf("expect %v, got %v", expect, env)
+	}
+}
@@ -0,0 +1,38 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// 正在运行的 mydocker exec 会话, 进程退出后从 ContainerInfo 中删除
+type ExecSession struct {
+	ID         string    `json:"id"`
+	Cmd        []string  `json:"cmd"`
+	Env        []string  `json:"env"`        // -e 和 --env-file 传入的额外环境变量
+	Pid        int       `json:"pid"`        // 宿主机上 nsenter 进程的 PID
+	TTY        bool      `json:"tty"`        // -ti
+	Detach     bool      `json:"detach"`     // -d, 输出写入容器的日志
+	Privileged bool      `json:"privileged"` // 不丢弃 capability
+	StartedAt  time.Time `json:"startedAt"`
+}
+
+func NewExecID() (string, error) {
+	b := make([]byte, containerIDBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 按 ID 删除 exec 会话
+func (c *ContainerInfo) RemoveExecSession(id string) {
+	sessions := c.ExecSessions[:0]
+	for _, s := range c.ExecSessions {
+		if s.ID != id {
+			sessions = append(sessions, s)
+		}
+	}
+	c.ExecSessions = sessions
+}
@@ -0,0 +1,66 @@
+package container
+
+import (
+	"time"
+)
+
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+
+	// 每个容器只保留最近几次健康检查的结果
+	MaxHealthLogEntries = 5
+	// 单次健康检查保留的最大输出长度
+	MaxHealthOutputLen = 4096
+
+	DefaultHealthInterval = 30 * time.Second
+	DefaultHealthTimeout  = 30 * time.Second
+	DefaultHealthRetries  = 3
+)
+
+// 健康检查配置, 对应 --health-cmd 等参数
+type HealthConfig struct {
+	Cmd         string        `json:"cmd"`         // 在容器内通过 sh -c 执行的检查命令
+	Interval    time.Duration `json:"interval"`    // 两次检查之间的间隔
+	Timeout     time.Duration `json:"timeout"`     // 单次检查的超时时间
+	StartPeriod time.Duration `json:"startPeriod"` // 容器启动后的初始化时间, 期间的失败不计入连续失败次数
+	Retries     int           `json:"retries"`     // 连续失败多少次后标记为 unhealthy
+}
+
+// 单次健康检查的结果
+type HealthcheckResult struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exitCode"`
+	Output   string    `json:"output"`
+}
+
+// 容器当前的健康状态
+type Health struct {
+	Status        string               `json:"status"`
+	FailingStreak int                  `json:"failingStreak"`
+	Log           []*HealthcheckResult `json:"log"`
+}
+
+// 根据一次检查的结果更新健康状态, inStartPeriod 表示容器还处于 start period 内
+func (h *Health) Update(result *HealthcheckResult, retries int, inStartPeriod bool) {
+	h.Log = append(h.Log, result)
+	if len(h.Log) > MaxHealthLogEntries {
+		h.Log = h.Log[len(h.Log)-MaxHealthLogEntries:]
+	}
+
+	if result.ExitCode == 0 {
+		h.Status = HealthHealthy
+		h.FailingStreak = 0
+		return
+	}
+	// start period 内还没有成功过的失败不计数
+	if inStartPeriod && h.Status == HealthStarting {
+		return
+	}
+	h.FailingStreak++
+	if h.FailingStreak >= retries {
+		h.Status = HealthUnhealthy
+	}
+}
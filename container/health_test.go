@@ -0,0 +1,32 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestHealthUpdate(t *testing.T) {
+	h := &Health{Status: HealthStarting}
+	// start period 内的失败不计数
+	h.Update(&HealthcheckResult{ExitCode: 1}, 2, true)
+	if h.Status != HealthStarting || h.FailingStreak != 0 {
+		t.Fatalf("failure in start period should be ignored: %+v", h)
+	}
+	h.Update(&HealthcheckResult{ExitCode: 0}, 2, true)
+	if h.Status != HealthHealthy {
+		t.Fatalf("expect healthy, got %s", h.Status)
+	}
+	h.Update(&HealthcheckResult{ExitCode: 1}, 2, false)
+	if h.Status != HealthHealthy || h.FailingStreak != 1 {
+		t.Fatalf("one failure should not be unhealthy: %+v", h)
+	}
+	h.Update(&HealthcheckResult{ExitCode: 1}, 2, false)
+	if h.Status != HealthUnhealthy {
+		t.Fatalf("expect unhealthy, got %s", h.Status)
+	}
+	for i := 0; i < 10; i++ {
+		h.Update(&HealthcheckResult{ExitCode: 0}, 2, false)
+	}
+	if len(h.Log) != MaxHealthLogEntries {
+		t.Fatalf("expect %d log entries, got %d", MaxHealthLogEntries, len(h.Log))
+	}
+}
@@ -0,0 +1,76 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// 容器 ID 为 32 字节随机数的十六进制表示
+	containerIDBytes = 32
+	// ps 等命令中显示的短 ID 长度
+	ShortIDLength = 12
+)
+
+// 生成一个新的容器 ID, 并确认它没有和已有容器的状态目录冲突
+func NewContainerID() (string, error) {
+	b := make([]byte, containerIDBytes)
+	for i := 0; i < 5; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		id := hex.EncodeToString(b)
+		exist, err := PathExists(fmt.Sprintf(DefaultInfoLocation, id))
+		if err != nil {
+			return "", err
+		}
+		if !exist {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("generate unique container id failed")
+}
+
+func ShortID(id string) string {
+	if len(id) > ShortIDLength {
+		return id[:ShortIDLength]
+	}
+	return id
+}
+
+// 按完整 ID, 容器名, ID 前缀的顺序查找容器
+// 前缀匹配到多个容器时返回错误, 避免操作到错误的容器
+func MatchContainer(containers []*ContainerInfo, ref string) (*ContainerInfo, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("container name or id is empty")
+	}
+	for _, info := range containers {
+		if info.Id == ref {
+			return info, nil
+		}
+	}
+	for _, info := range containers {
+		if info.Name == ref {
+			return info, nil
+		}
+	}
+	var matches []*ContainerInfo
+	for _, info := range containers {
+		if strings.HasPrefix(info.Id, ref) {
+			matches = append(matches, info)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("No such container: %s", ref)
+	case 1:
+		return matches[0], nil
+	}
+	ids := make([]string, 0, len(matches))
+	for _, info := range matches {
+		ids = append(ids, ShortID(info.Id))
+	}
+	return nil, fmt.Errorf("multiple containers found with provided prefix %s: %s", ref, strings.Join(ids, ", "))
+}
@@ -0,0 +1,36 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestMatchContainer(t *testing.T) {
+	containers := []*ContainerInfo{
+		{Id: "abc123", Name: "web"},
+		{Id: "abd456", Name: "db"},
+		{Id: "ffee00", Name: "abc"},
+	}
+	cases := map[string]string{
+		"abc123": "abc123",
+		"web":    "abc123",
+		"abd":    "abd456",
+		"f":      "ffee00",
+		// 容器名优先于 ID 前缀
+		"abc": "ffee00",
+	}
+	for ref, id := range cases {
+		info, err := MatchContainer(containers, ref)
+		if err != nil {
+			t.Fatalf("match %s error %v", ref, err)
+		}
+		if info.Id != id {
+			t.Fatalf("match %s expect %s, got %s", ref, id, info.Id)
+		}
+	}
+	if _, err := MatchContainer(containers, "ab"); err == nil {
+		t.Fatalf("ambiguous prefix should return error")
+	}
+	if _, err := MatchContainer(containers, "zz"); err == nil {
+		t.Fatalf("unknown container should return error")
+	}
+}
@@ -1,21 +1,32 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"mydocker/image"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"syscall"
 
-	"io"
-
 	"github.com/sirupsen/logrus"
 )
 
+// 通过管道传给容器 init 进程的配置, 以 JSON 格式传递, 命令的参数中可以有空格
+type InitConfig struct {
+	Args       []string `json:"args"`
+	WorkingDir string   `json:"workingDir"`
+	User       string   `json:"user"`
+}
+
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if len(cmdArray) == 0 {
+	config, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Args) == 0 {
 		return fmt.Errorf("Run container get user command error, cmdArray is nil")
 	}
 
@@ -23,31 +34,88 @@ func RunContainerInitProcess() error {
 
 	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 	_ = syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
+	if err := setUpProcess(config); err != nil {
+		logrus.Errorf("Set up process error %v", err)
+		return err
+	}
 	// LookPath 会去 PATH 查找, 而 SYS_EXECVE 需要完整的路径
-	path, err := exec.LookPath(cmdArray[0])
+	path, err := exec.LookPath(config.Args[0])
 	if err != nil {
 		logrus.Errorf("Exec loop path error %v", err)
 		return err
 	}
 
 	logrus.Infof("Find path %s", path)
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(path, config.Args, os.Environ()); err != nil {
 		logrus.Errorf(err.Error())
 	}
 	return nil
 }
 
-func readUserCommand() []string {
+// mydocker exec 的命令在 nsenter 进入容器的 namespace 之后执行, 和容器 init 进程一样切换工作目录和用户
+func RunExecProcess(args []string, user, workingDir string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command")
+	}
+	if err := setUpProcess(&InitConfig{Args: args, WorkingDir: workingDir, User: user}); err != nil {
+		return err
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
+
+func readInitConfig() (*InitConfig, error) {
 	// 3 就是 NewPipe 创建的那个管道
 	// 存储了 command
 	pipe := os.NewFile(uintptr(3), "pipe")
 	msg, err := io.ReadAll(io.Reader(pipe))
 	if err != nil {
 		logrus.Errorf("init read pipe error %v", err)
-		return nil
+		return nil, err
+	}
+	var config InitConfig
+	if err := json.Unmarshal(msg, &config); err != nil {
+		return nil, fmt.Errorf("init parse config error %v", err)
+	}
+	return &config, nil
+}
+
+// 切换到镜像配置的工作目录和用户, 在 pivot_root 之后执行, 用户按容器内的 /etc/passwd 解析
+func setUpProcess(config *InitConfig) error {
+	user, err := LookupUser(config.User)
+	if err != nil {
+		return err
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	if os.Getenv("HOME") == "" {
+		os.Setenv("HOME", user.Home)
+	}
+	// 没有 PATH 时 LookPath 找不到命令, 使用和 docker 一样的默认值
+	if os.Getenv("PATH") == "" {
+		os.Setenv("PATH", strings.TrimPrefix(image.DefaultPathEnv, "PATH="))
+	}
+	if config.WorkingDir != "" {
+		// 工作目录不存在时自动创建, 和 docker 一致
+		if err := os.MkdirAll(config.WorkingDir, 0755); err != nil {
+			return err
+		}
+		if err := os.Chdir(config.WorkingDir); err != nil {
+			return err
+		}
+	}
+	// 先设置组, 切换用户之后就没有权限了
+	if err := syscall.Setgroups(user.Groups); err != nil {
+		return fmt.Errorf("setgroups %v", err)
+	}
+	if err := syscall.Setgid(user.Gid); err != nil {
+		return fmt.Errorf("setgid %d %v", user.Gid, err)
+	}
+	if err := syscall.Setuid(user.Uid); err != nil {
+		return fmt.Errorf("setuid %d %v", user.Uid, err)
+	}
+	return nil
 }
 
 // init 挂载点
@@ -0,0 +1,66 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 路径中最多允许跟随的符号链接数, 和内核的限制一致
+const maxSymlinkFollows = 40
+
+// 把容器内的路径 unsafePath 解析为宿主机上 root 下的路径
+// 逐级解析符号链接, 绝对路径的链接相对于 root 解析, .. 最多回到 root, 结果不会跑出 root
+// 不存在的部分按字面拼接
+func SecureJoin(root, unsafePath string) (string, error) {
+	current := ""
+	follows := 0
+	for unsafePath != "" {
+		var part string
+		if i := strings.IndexByte(unsafePath, '/'); i >= 0 {
+			part, unsafePath = unsafePath[:i], unsafePath[i+1:]
+		} else {
+			part, unsafePath = unsafePath, ""
+		}
+		next := filepath.Clean("/" + current + "/" + part)
+		if next == "/" {
+			current = ""
+			continue
+		}
+		fullPath := filepath.Join(root, next)
+		fi, err := os.Lstat(fullPath)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		follows++
+		if follows > maxSymlinkFollows {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", next)
+		}
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return "", err
+		}
+		// 相对链接相对于链接所在的目录, 也就是 current
+		if filepath.IsAbs(target) {
+			current = ""
+		}
+		unsafePath = target + "/" + unsafePath
+	}
+	return filepath.Join(root, filepath.Clean("/"+current)), nil
+}
+
+// 和 SecureJoin 一样, 但不跟随最后一级的符号链接
+func SecureJoinNoFollow(root, unsafePath string) (string, error) {
+	cleaned := filepath.Clean("/" + unsafePath)
+	if cleaned == "/" {
+		return filepath.Clean(root), nil
+	}
+	dir, err := SecureJoin(root, filepath.Dir(cleaned))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(cleaned)), nil
+}
@@ -0,0 +1,55 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"etc", "var/lib"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"abs":        "/etc",
+		"rel":        "var/lib",
+		"escape":     "../../../../etc",
+		"var/parent": "..",
+		"loop":       "loop",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]string{
+		"/etc/passwd":         "/etc/passwd",
+		"abs/passwd":          "/etc/passwd",
+		"/rel/x":              "/var/lib/x",
+		"escape/shadow":       "/etc/shadow",
+		"../../etc":           "/etc",
+		"/var/parent/etc":     "/etc",
+		"/missing/../etc/foo": "/etc/foo",
+	}
+	for unsafePath, expect := range cases {
+		got, err := SecureJoin(root, unsafePath)
+		if err != nil {
+			t.Fatalf("secure join %s error %v", unsafePath, err)
+		}
+		if got != filepath.Join(root, expect) {
+			t.Fatalf("secure join %s: expect %s, got %s", unsafePath, filepath.Join(root, expect), got)
+		}
+	}
+	if _, err := SecureJoin(root, "loop/x"); err == nil {
+		t.Fatalf("expect error for symlink loop")
+	}
+
+	got, err := SecureJoinNoFollow(root, "/var/../abs")
+	if err != nil || got != filepath.Join(root, "abs") {
+		t.Fatalf("secure join no follow: got %s %v", got, err)
+	}
+}
@@ -0,0 +1,84 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	RestartPolicyNo            = "no"
+	RestartPolicyAlways        = "always"
+	RestartPolicyOnFailure     = "on-failure"
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+// 容器的重启策略, 对应 --restart no|on-failure[:N]|always|unless-stopped
+type RestartPolicy struct {
+	Name              string `json:"name"`
+	MaximumRetryCount int    `json:"maximumRetryCount"` // 只对 on-failure 生效, 0 表示不限制次数
+}
+
+// 解析 --restart 参数, 空字符串等同于 no
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	if policy == "" {
+		return RestartPolicy{Name: RestartPolicyNo}, nil
+	}
+	name, count, hasCount := strings.Cut(policy, ":")
+	p := RestartPolicy{Name: name}
+	switch name {
+	case RestartPolicyNo, RestartPolicyAlways, RestartPolicyUnlessStopped:
+		if hasCount {
+			return p, fmt.Errorf("maximum retry count cannot be used with restart policy '%s'", name)
+		}
+	case RestartPolicyOnFailure:
+		if hasCount {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 0 {
+				return p, fmt.Errorf("invalid maximum retry count: %s", count)
+			}
+			p.MaximumRetryCount = n
+		}
+	default:
+		return p, fmt.Errorf("invalid restart policy '%s'", policy)
+	}
+	return p, nil
+}
+
+func (p RestartPolicy) String() string {
+	if p.Name == "" {
+		return RestartPolicyNo
+	}
+	if p.Name == RestartPolicyOnFailure && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+	return p.Name
+}
+
+// 容器退出后是否需要重启, mydocker stop 停止的容器不会再重启
+func (p RestartPolicy) ShouldRestart(exitCode, restartCount int, manuallyStopped bool) bool {
+	if manuallyStopped {
+		return false
+	}
+	switch p.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		if exitCode == 0 {
+			return false
+		}
+		return p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount
+	}
+	return false
+}
+
+// 宿主机重启后 mydocker recover 是否需要恢复这个容器
+func (p RestartPolicy) ShouldRecover(manuallyStopped bool) bool {
+	switch p.Name {
+	case RestartPolicyAlways:
+		return true
+	case RestartPolicyUnlessStopped:
+		return !manuallyStopped
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	cases := map[string]RestartPolicy{
+		"":               {Name: RestartPolicyNo},
+		"no":             {Name: RestartPolicyNo},
+		"always":         {Name: RestartPolicyAlways},
+		"unless-stopped": {Name: RestartPolicyUnlessStopped},
+		"on-failure":     {Name: RestartPolicyOnFailure},
+		"on-failure:3":   {Name: RestartPolicyOnFailure, MaximumRetryCount: 3},
+	}
+	for policy, expect := range cases {
+		p, err := ParseRestartPolicy(policy)
+		if err != nil {
+			t.Fatalf("parse restart policy %q error %v", policy, err)
+		}
+		if !reflect.DeepEqual(p, expect) {
+			t.Fatalf("parse restart policy %q: expect %+v, got %+v", policy, expect, p)
+		}
+	}
+	for _, policy := range []string{"sometimes", "always:3", "on-failure:-1", "on-failure:x"} {
+		if _, err := ParseRestartPolicy(policy); err == nil {
+			t.Fatalf("restart policy %q should be invalid", policy)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	p, _ := ParseRestartPolicy("on-failure:2")
+	if p.ShouldRestart(0, 0, false) {
+		t.Fatalf("on-failure should not restart on exit code 0")
+	}
+	if !p.ShouldRestart(1, 1, false) {
+		t.Fatalf("on-failure:2 should restart after 1 retry")
+	}
+	if p.ShouldRestart(1, 2, false) {
+		t.Fatalf("on-failure:2 should stop after 2 retries")
+	}
+	always, _ := ParseRestartPolicy("always")
+	if always.ShouldRestart(0, 100, true) {
+		t.Fatalf("manually stopped container should not restart")
+	}
+	unlessStopped, _ := ParseRestartPolicy("unless-stopped")
+	if unlessStopped.ShouldRecover(true) || !always.ShouldRecover(true) {
+		t.Fatalf("unexpected recover result")
+	}
+}
@@ -0,0 +1,133 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 容器进程的用户, 由镜像配置中的 User 解析得到
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int // 附加组
+	Home   string
+}
+
+// 按容器内的 /etc/passwd 和 /etc/group 解析 user[:group] 或 uid[:gid]
+// 为空时使用 root, 数字形式的 uid 可以不在 /etc/passwd 中, 这时 gid 为 0
+func LookupUser(spec string) (*ExecUser, error) {
+	passwd, _ := os.Open("/etc/passwd")
+	group, _ := os.Open("/etc/group")
+	// 文件不存在时按空文件处理, 只能使用数字形式
+	var passwdReader, groupReader io.Reader = strings.NewReader(""), strings.NewReader("")
+	if passwd != nil {
+		defer passwd.Close()
+		passwdReader = passwd
+	}
+	if group != nil {
+		defer group.Close()
+		groupReader = group
+	}
+	return parseUser(spec, passwdReader, groupReader)
+}
+
+func parseUser(spec string, passwd, group io.Reader) (*ExecUser, error) {
+	userSpec, groupSpec, hasGroup := strings.Cut(spec, ":")
+	if userSpec == "" {
+		userSpec = "0"
+	}
+	user := &ExecUser{Home: "/"}
+	uid, uidErr := strconv.Atoi(userSpec)
+	found := false
+	var name string
+	for _, fields := range readColonFile(passwd, 7) {
+		if fields[0] == userSpec || (uidErr == nil && fields[2] == userSpec) {
+			var err1, err2 error
+			user.Uid, err1 = strconv.Atoi(fields[2])
+			user.Gid, err2 = strconv.Atoi(fields[3])
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			name = fields[0]
+			user.Home = fields[5]
+			found = true
+			break
+		}
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userSpec)
+		}
+		if uid < 0 {
+			return nil, fmt.Errorf("invalid uid %d", uid)
+		}
+		// 和 docker 一致, 不在 /etc/passwd 中的 uid 使用 gid 0
+		user.Uid = uid
+		user.Gid = 0
+	}
+
+	groups := readColonFile(group, 4)
+	if hasGroup {
+		gid, gidErr := strconv.Atoi(groupSpec)
+		found = false
+		for _, fields := range groups {
+			if fields[0] == groupSpec || (gidErr == nil && fields[2] == groupSpec) {
+				if g, err := strconv.Atoi(fields[2]); err == nil {
+					user.Gid = g
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			if gidErr != nil {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupSpec)
+			}
+			if gid < 0 {
+				return nil, fmt.Errorf("invalid gid %d", gid)
+			}
+			user.Gid = gid
+		}
+		// 指定了组时不使用附加组, 和 docker 一致
+		user.Groups = []int{user.Gid}
+		return user, nil
+	}
+	// 用户所在的其他组作为附加组
+	user.Groups = []int{user.Gid}
+	if name == "" {
+		return user, nil
+	}
+	for _, fields := range groups {
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != name {
+				continue
+			}
+			if gid, err := strconv.Atoi(fields[2]); err == nil && gid != user.Gid {
+				user.Groups = append(user.Groups, gid)
+			}
+		}
+	}
+	return user, nil
+}
+
+// 读取 /etc/passwd 格式的文件, 忽略字段数不足的行和注释
+func readColonFile(r io.Reader, fieldCount int) [][]string {
+	var entries [][]string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < fieldCount {
+			continue
+		}
+		entries = append(entries, fields)
+	}
+	return entries
+}
@@ -0,0 +1,59 @@
+package container
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/bin/sh
+# comment
+nobody:x:65534:65534:nobody:/nonexistent:/bin/false
+www:x:33:33:www:/var/www:/bin/sh
+`
+
+const testGroup = `root:x:0:
+adm:x:4:www
+nogroup:x:65534:
+www:x:33:
+staff:x:50:root,www
+`
+
+func TestParseUser(t *testing.T) {
+	cases := map[string]ExecUser{
+		"":          {Uid: 0, Gid: 0, Groups: []int{0, 50}, Home: "/root"},
+		"www":       {Uid: 33, Gid: 33, Groups: []int{33, 4, 50}, Home: "/var/www"},
+		"33":        {Uid: 33, Gid: 33, Groups: []int{33, 4, 50}, Home: "/var/www"},
+		"www:staff": {Uid: 33, Gid: 50, Groups: []int{50}, Home: "/var/www"},
+		"1000":      {Uid: 1000, Gid: 0, Groups: []int{0}, Home: "/"},
+		"1000:2000": {Uid: 1000, Gid: 2000, Groups: []int{2000}, Home: "/"},
+	}
+	for spec, expect := range cases {
+		user, err := parseUser(spec, strings.NewReader(testPasswd), strings.NewReader(testGroup))
+		if err != nil {
+			t.Fatalf("parse user %q error %v", spec, err)
+		}
+		if !reflect.DeepEqual(*user, expect) {
+			t.Fatalf("parse user %q: expect %+v, got %+v", spec, expect, *user)
+		}
+	}
+	for _, spec := range []string{"nosuchuser", "www:nosuchgroup", "-1"} {
+		if _, err := parseUser(spec, strings.NewReader(testPasswd), strings.NewReader(testGroup)); err == nil {
+			t.Fatalf("user %q should be invalid", spec)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	for s, expect := range map[string]int{"": 15, "SIGQUIT": 3, "quit": 3, "9": 9} {
+		sig, err := ParseSignal(s)
+		if err != nil || int(sig) != expect {
+			t.Fatalf("parse signal %q: expect %d, got %d %v", s, expect, sig, err)
+		}
+	}
+	for _, s := range []string{"SIGFOO", "0", "100"} {
+		if _, err := ParseSignal(s); err == nil {
+			t.Fatalf("signal %q should be invalid", s)
+		}
+	}
+}
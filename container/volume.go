@@ -2,120 +2,141 @@ package container
 
 import (
 	"fmt"
+	"mydocker/graphdriver"
+	"mydocker/image"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/sirupsen/logrus"
 )
 
-// Create a AUFS filesystem as container root workspace
-func NewWorkSpace(volume, imageName, containerName string) {
-	CreateReadOnlyLayer(imageName)
-	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+// 容器使用的存储驱动, 旧版本的容器没有记录存储驱动, 都是 aufs
+func StorageDriver(name string) (graphdriver.Driver, error) {
+	if name == "" {
+		name = graphdriver.AufsDriver
+	}
+	return graphdriver.New(name)
+}
+
+// 由存储驱动创建容器的可写层并挂载根文件系统, 失败时容器不能启动
+func NewWorkSpace(driverName, volume, imageName, containerId string) error {
+	driver, err := StorageDriver(driverName)
+	if err != nil {
+		return fmt.Errorf("get storage driver error %v", err)
+	}
+	layerDirs, err := image.LayerDirs(imageName, driver)
+	if err != nil {
+		return fmt.Errorf("prepare layers of image %s error %v", imageName, err)
+	}
+	if err := driver.Create(containerId, layerDirs); err != nil {
+		return fmt.Errorf("create write layer of container %s error %v", containerId, err)
+	}
+	if err := CreateMountPoint(driver, containerId, layerDirs); err != nil {
+		return fmt.Errorf("mount rootfs of container %s error %v", containerId, err)
+	}
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			MountVolume(volumeURLs, containerName)
+			if err := MountVolume(volumeURLs, containerId); err != nil {
+				return fmt.Errorf("mount volume %s error %v", volume, err)
+			}
 			logrus.Infof("NewWorkSpace volume urls %q", volumeURLs)
 		} else {
 			logrus.Infof("Volume parameter input is not correct.")
 		}
 	}
-}
-
-// Decompression tar image
-func CreateReadOnlyLayer(imageName string) error {
-	unTarFolderUrl := RootUrl + "/" + imageName + "/"
-	imageUrl := RootUrl + "/" + imageName + ".tar"
-	exist, err := PathExists(unTarFolderUrl)
-	if err != nil {
-		logrus.Infof("Fail to judge whether dir %s exists. %v", unTarFolderUrl, err)
-		return err
-	}
-	if !exist {
-		if err := os.MkdirAll(unTarFolderUrl, 0622); err != nil {
-			logrus.Errorf("Mkdir %s error %v", unTarFolderUrl, err)
-			return err
-		}
-
-		if _, err := exec.Command("tar", "-xvf", imageUrl, "-C", unTarFolderUrl).CombinedOutput(); err != nil {
-			logrus.Errorf("Untar dir %s error %v", unTarFolderUrl, err)
-			return err
-		}
-	}
 	return nil
 }
 
-func CreateWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.MkdirAll(writeURL, 0777); err != nil {
-		logrus.Infof("Mkdir write layer dir %s error. %v", writeURL, err)
-	}
-}
-
-func MountVolume(volumeURLs []string, containerName string) error {
+// 数据卷通过 bind mount 挂载到容器内
+func MountVolume(volumeURLs []string, containerId string) error {
 	parentUrl := volumeURLs[0]
 	if err := os.Mkdir(parentUrl, 0777); err != nil {
 		logrus.Infof("Mkdir parent dir %s error. %v", parentUrl, err)
 	}
 	containerUrl := volumeURLs[1]
-	mntURL := fmt.Sprintf(MntUrl, containerName)
+	mntURL := fmt.Sprintf(MntUrl, containerId)
 	containerVolumeURL := mntURL + "/" + containerUrl
-	if err := os.Mkdir(containerVolumeURL, 0777); err != nil {
+	// 容器重启时数据卷已经挂载好了
+	if IsMountPoint(containerVolumeURL) {
+		return nil
+	}
+	if err := os.MkdirAll(containerVolumeURL, 0777); err != nil {
 		logrus.Infof("Mkdir container dir %s error. %v", containerVolumeURL, err)
 	}
-	dirs := "dirs=" + parentUrl
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumeURL).CombinedOutput()
-	if err != nil {
+	if err := syscall.Mount(parentUrl, containerVolumeURL, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
 		logrus.Errorf("Mount volume failed. %v", err)
 		return err
 	}
 	return nil
 }
 
-func CreateMountPoint(containerName, imageName string) error {
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	if err := os.MkdirAll(mntUrl, 0777); err != nil {
-		logrus.Errorf("Mkdir mountpoint dir %s error. %v", mntUrl, err)
+// layerDirs 为镜像各层解压后的目录, 从最上层到最底层排列
+func CreateMountPoint(driver graphdriver.Driver, containerId string, layerDirs []string) error {
+	mntURL := fmt.Sprintf(MntUrl, containerId)
+	// 容器重启时复用之前的挂载点, 可写层中的修改需要保留
+	if IsMountPoint(mntURL) {
+		return nil
+	}
+	if err := os.MkdirAll(mntURL, 0777); err != nil {
+		logrus.Errorf("Mkdir mountpoint dir %s error. %v", mntURL, err)
 		return err
 	}
-	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL).CombinedOutput()
+	if err := driver.Mount(containerId, layerDirs, mntURL); err != nil {
+		logrus.Errorf("Create mount point failed %v", err)
+		return err
+	}
+	return nil
+}
+
+// 卸载容器的根文件系统并删除可写层, 卸载失败时保留可写层并返回错误
+func DeleteWorkSpace(driverName, volume, containerId string) error {
+	driver, err := StorageDriver(driverName)
 	if err != nil {
-		logrus.Errorf("Run command for creating mount point failed %v", err)
+		logrus.Errorf("Get storage driver error %v", err)
 		return err
 	}
+	// 卸载失败时根文件系统或数据卷还挂载着, 删除可写层会删掉挂载着的内容, 甚至是宿主机上数据卷中的文件
+	if err := unmountWorkSpace(driver, volume, containerId); err != nil {
+		return fmt.Errorf("unmount rootfs of container %s error %v", containerId, err)
+	}
+	if err := driver.Remove(containerId); err != nil {
+		logrus.Infof("Remove write layer of container %s error %v", containerId, err)
+	}
 	return nil
 }
 
-// Delete the AUFS filesystem while container exit
-func DeleteWorkSpace(volume, containerName string) {
+// 卸载容器的数据卷和根文件系统, 保留可写层, 和 NewWorkSpace 对应
+func UnmountWorkSpace(driverName, volume, containerId string) error {
+	driver, err := StorageDriver(driverName)
+	if err != nil {
+		return err
+	}
+	return unmountWorkSpace(driver, volume, containerId)
+}
+
+func unmountWorkSpace(driver graphdriver.Driver, volume, containerId string) error {
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			DeleteMountPointWithVolume(volumeURLs, containerName)
-		} else {
-			DeleteMountPoint(containerName)
+			return DeleteMountPointWithVolume(driver, volumeURLs, containerId)
 		}
-	} else {
-		DeleteMountPoint(containerName)
 	}
-	DeleteWriteLayer(containerName)
+	return DeleteMountPoint(driver, containerId)
 }
 
-func DeleteMountPoint(containerName string) error {
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	_, err := exec.Command("umount", mntURL).CombinedOutput()
-	if err != nil {
-		logrus.Errorf("Unmount %s error %v", mntURL, err)
-		return err
+// 没有挂载时只删除挂载点目录, 例如容器启动失败或者宿主机重启之后
+func DeleteMountPoint(driver graphdriver.Driver, containerId string) error {
+	mntURL := fmt.Sprintf(MntUrl, containerId)
+	if IsMountPoint(mntURL) {
+		if err := driver.Unmount(mntURL); err != nil {
+			logrus.Errorf("%v", err)
+			return err
+		}
 	}
 	if err := os.RemoveAll(mntURL); err != nil {
 		logrus.Errorf("Remove mountpoint dir %s error %v", mntURL, err)
@@ -124,17 +145,21 @@ func DeleteMountPoint(containerName string) error {
 	return nil
 }
 
-func DeleteMountPointWithVolume(volumeURLs []string, containerName string) error {
-	mntURL := fmt.Sprintf(MntUrl, containerName)
+func DeleteMountPointWithVolume(driver graphdriver.Driver, volumeURLs []string, containerId string) error {
+	mntURL := fmt.Sprintf(MntUrl, containerId)
 	containerUrl := mntURL + "/" + volumeURLs[1]
-	if _, err := exec.Command("umount", containerUrl).CombinedOutput(); err != nil {
-		logrus.Errorf("Umount volume %s failed. %v", containerUrl, err)
-		return err
+	if IsMountPoint(containerUrl) {
+		if err := syscall.Unmount(containerUrl, 0); err != nil {
+			logrus.Errorf("Umount volume %s failed. %v", containerUrl, err)
+			return err
+		}
 	}
 
-	if _, err := exec.Command("umount", mntURL).CombinedOutput(); err != nil {
-		logrus.Errorf("Umount mountpoint %s failed. %v", mntURL, err)
-		return err
+	if IsMountPoint(mntURL) {
+		if err := driver.Unmount(mntURL); err != nil {
+			logrus.Errorf("Umount mountpoint failed. %v", err)
+			return err
+		}
 	}
 
 	if err := os.RemoveAll(mntURL); err != nil {
@@ -144,13 +169,6 @@ func DeleteMountPointWithVolume(volumeURLs []string, containerName string) error
 	return nil
 }
 
-func DeleteWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.RemoveAll(writeURL); err != nil {
-		logrus.Infof("Remove writeLayer dir %s error %v", writeURL, err)
-	}
-}
-
 func PathExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -161,3 +179,20 @@ func PathExists(path string) (bool, error) {
 	}
 	return false, err
 }
+
+// 通过 /proc/self/mountinfo 判断 path 是否是一个挂载点
+func IsMountPoint(path string) bool {
+	content, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	path = filepath.Clean(path)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, " ")
+		// 第 5 个字段是挂载点
+		if len(fields) > 4 && fields[4] == path {
+			return true
+		}
+	}
+	return false
+}
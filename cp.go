@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mydocker/container"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 把 cp 参数中的路径解析为宿主机上的实际路径, follow 表示是否跟随最后一级的符号链接
+type pathResolver func(path string, follow bool) (string, error)
+
+// 宿主机上的路径不需要限制在某个根目录下
+func hostResolver(path string, follow bool) (string, error) {
+	if follow {
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			return resolved, nil
+		}
+	}
+	return filepath.Clean(path), nil
+}
+
+// 容器内的路径限制在容器的根目录下解析
+func containerResolver(root string) pathResolver {
+	return func(path string, follow bool) (string, error) {
+		if follow {
+			return container.SecureJoin(root, path)
+		}
+		return container.SecureJoinNoFollow(root, path)
+	}
+}
+
+// 在宿主机和容器之间复制文件, src 和 dst 中有且只有一个是 容器名:路径 的形式
+func copyFiles(src, dst string, followLink bool) error {
+	srcContainer, srcPath := splitCpArg(src)
+	dstContainer, dstPath := splitCpArg(dst)
+	if srcContainer != "" && dstContainer != "" {
+		return fmt.Errorf("copying between containers is not supported")
+	}
+	if srcContainer == "" && dstContainer == "" {
+		return fmt.Errorf("must specify at least one container source")
+	}
+
+	srcResolver, dstResolver := pathResolver(hostResolver), pathResolver(hostResolver)
+	if srcContainer != "" {
+		root, release, err := containerRoot(srcContainer)
+		if err != nil {
+			return err
+		}
+		defer release()
+		srcResolver = containerResolver(root)
+	} else {
+		root, release, err := containerRoot(dstContainer)
+		if err != nil {
+			return err
+		}
+		defer release()
+		dstResolver = containerResolver(root)
+	}
+	return copyPath(srcResolver, srcPath, dstResolver, dstPath, followLink)
+}
+
+// 容器名:路径 形式的参数返回容器名和路径, 宿主机路径返回空的容器名
+// 冒号前面有 / 时认为是宿主机上的路径, 例如 ./a:b
+func splitCpArg(arg string) (string, string) {
+	if filepath.IsAbs(arg) {
+		return "", arg
+	}
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.Contains(parts[0], "/") {
+		return "", arg
+	}
+	path := parts[1]
+	if path == "" {
+		path = "/"
+	}
+	return parts[0], path
+}
+
+// 运行中的容器通过 /proc/<pid>/root 访问, 可以看到容器 mount namespace 里的数据卷
+// 停止的容器使用宿主机上的挂载点, 没有挂载时临时挂载, 复制完成后调用返回的 release 卸载
+func containerRoot(containerName string) (string, func(), error) {
+	info, err := resolveContainer(containerName)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.Status == container.RUNNING && strings.TrimSpace(info.Pid) != "" {
+		return fmt.Sprintf("/proc/%s/root", strings.TrimSpace(info.Pid)), func() {}, nil
+	}
+	mntURL := fmt.Sprintf(container.MntUrl, info.Id)
+	if container.IsMountPoint(mntURL) {
+		return mntURL, func() {}, nil
+	}
+	if err := container.NewWorkSpace(info.StorageDriver, info.Volume, info.ImageRef(), info.Id); err != nil {
+		return "", nil, err
+	}
+	release := func() {
+		// 复制期间容器可能启动了, 这时挂载点由容器使用
+		if latest, err := getContainerInfoById(info.Id); err == nil && latest.Status == container.RUNNING {
+			return
+		}
+		if err := container.UnmountWorkSpace(info.StorageDriver, info.Volume, info.Id); err != nil {
+			logrus.Warnf("Unmount rootfs of container %s error %v", containerName, err)
+		}
+	}
+	return mntURL, release, nil
+}
+
+func copyPath(srcResolver pathResolver, srcPath string, dstResolver pathResolver, dstPath string, followLink bool) error {
+	srcFull, err := srcResolver(srcPath, followLink)
+	if err != nil {
+		return err
+	}
+	srcInfo, err := os.Lstat(srcFull)
+	if err != nil {
+		return fmt.Errorf("could not find the file %s: %v", srcPath, err)
+	}
+
+	// 和 docker cp 一样决定目标路径:
+	// 目标是已存在的目录时复制到目录下, src 以 /. 结尾时只复制目录的内容
+	target := dstPath
+	dstFull, err := dstResolver(dstPath, true)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := os.Stat(dstFull)
+	switch {
+	case err == nil && dstInfo.IsDir():
+		if !(srcInfo.IsDir() && strings.HasSuffix(srcPath, "/.")) {
+			target = filepath.Join(dstPath, filepath.Base(filepath.Clean(srcPath)))
+		}
+	case err == nil && srcInfo.IsDir():
+		return fmt.Errorf("cannot copy a directory to a file %s", dstPath)
+	case err != nil && !os.IsNotExist(err):
+		return err
+	case err != nil && strings.HasSuffix(dstPath, "/"):
+		return fmt.Errorf("destination directory %s does not exist", dstPath)
+	}
+	targetParent, err := dstResolver(filepath.Dir(filepath.Clean(target)), true)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(targetParent); err != nil || !fi.IsDir() {
+		return fmt.Errorf("destination directory %s does not exist", filepath.Dir(filepath.Clean(target)))
+	}
+
+	// 目录的修改时间在目录中的文件都写完之后再设置
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirTimes []dirTime
+	err = filepath.Walk(srcFull, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcFull, path)
+		if err != nil {
+			return err
+		}
+		// 每一个目标路径都重新在目标的根目录下解析, 防止通过已有的符号链接写到根目录之外
+		out, err := dstResolver(filepath.Join(target, rel), false)
+		if err != nil {
+			return err
+		}
+		if err := copyEntry(path, out, info); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirTimes = append(dirTimes, dirTime{path: out, mtime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		_ = os.Chtimes(dirTimes[i].path, dirTimes[i].mtime, dirTimes[i].mtime)
+	}
+	return nil
+}
+
+// 复制一个文件, 目录, 符号链接或设备文件, 保留权限, 属主和修改时间
+func copyEntry(src, dst string, info os.FileInfo) error {
+	existing, err := os.Lstat(dst)
+	exists := err == nil
+	// 目标已存在且类型不同时先删除, 不会通过目标位置的符号链接写到别的地方
+	if exists && !(existing.IsDir() && info.IsDir()) && !(existing.Mode().IsRegular() && info.Mode().IsRegular()) {
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+
+	stat, _ := info.Sys().(*syscall.Stat_t)
+	mode := info.Mode()
+	switch {
+	case mode.IsDir():
+		if err := os.Mkdir(dst, mode.Perm()); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case mode.IsRegular():
+		if err := copyRegularFile(src, dst, mode.Perm()); err != nil {
+			return err
+		}
+	case mode&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+	case mode&(os.ModeDevice|os.ModeNamedPipe) != 0 && stat != nil:
+		if err := syscall.Mknod(dst, stat.Mode, int(stat.Rdev)); err != nil {
+			return err
+		}
+	default:
+		logrus.Warnf("Skip copying %s with unsupported file type %s", src, mode.Type())
+		return nil
+	}
+
+	if stat != nil {
+		if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+			logrus.Warnf("Chown %s error %v", dst, err)
+		}
+	}
+	if mode&os.ModeSymlink == 0 {
+		// 包括 setuid, setgid 和 sticky 位, chown 会清除 setuid 位所以放在后面
+		if stat != nil {
+			_ = syscall.Chmod(dst, stat.Mode&07777)
+		}
+		if !mode.IsDir() {
+			_ = os.Chtimes(dst, info.ModTime(), info.ModTime())
+		}
+	}
+	return nil
+}
+
+func copyRegularFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// O_NOFOLLOW: 目标位置在检查之后被换成符号链接时直接失败
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mydocker/archive"
+	"mydocker/container"
+	"mydocker/image"
+	"os"
+)
+
+// 列出容器可写层相对镜像的修改, format 为 json 时输出 JSON 数组
+func diffContainer(containerName, format string) error {
+	if format != "" && format != "json" {
+		return fmt.Errorf("unsupported format %s, only json is supported", format)
+	}
+	info, err := resolveContainer(containerName)
+	if err != nil {
+		return err
+	}
+	driver, err := container.StorageDriver(info.StorageDriver)
+	if err != nil {
+		return err
+	}
+	imageLayers, err := image.LayerDirs(info.ImageRef(), driver)
+	if err != nil {
+		return fmt.Errorf("get layers of image %s error %v", info.Image, err)
+	}
+	changes, err := driver.Changes(info.Id, imageLayers)
+	if err != nil {
+		return fmt.Errorf("get changes of container %s error %v", containerName, err)
+	}
+
+	if format == "json" {
+		if changes == nil {
+			changes = []archive.Change{}
+		}
+		content, err := json.Marshal(changes)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(content))
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Fprintln(os.Stdout, change.String())
+	}
+	return nil
+}
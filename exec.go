@@ -1,59 +1,268 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"mydocker/cgroups"
 	"mydocker/container"
+	"mydocker/logger"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	_ "mydocker/nsenter"
 
 	"github.com/sirupsen/logrus"
 )
 
+// nsenter 包里的 C 构造函数通过这些环境变量拿到容器的 pid 和 cgroup
+// 要执行的命令直接作为 /proc/self/exe exec 之后的参数传入
 const ENV_EXEC_PID = "mydocker_pid"
-const ENV_EXEC_CMD = "mydocker_cmd"
+const ENV_EXEC_CGROUP = "mydocker_cgroup"
 
-func ExecContainer(containerName string, comArray []string) {
-	pid, err := GetContainerPidByName(containerName)
+// 设置后 nsenter 不丢弃 capability
+const ENV_EXEC_PRIVILEGED = "mydocker_privileged"
+
+// 容器进程的用户和工作目录, exec 的命令使用同样的设置
+const ENV_EXEC_USER = "mydocker_user"
+const ENV_EXEC_WORKDIR = "mydocker_workdir"
+
+type execOptions struct {
+	TTY        bool
+	Detach     bool
+	Privileged bool
+	Env        []string // -e 和 --env-file 传入的额外环境变量
+}
+
+// 在容器内执行命令, 返回命令的退出码
+// -d 时由后台的 exec-monitor 进程执行命令, 立即返回 0
+func ExecContainer(containerName string, comArray []string, opts execOptions) (int, error) {
+	containerInfo, err := resolveContainer(containerName)
+	if err != nil {
+		return 0, err
+	}
+	if containerInfo.Status != container.RUNNING || strings.TrimSpace(containerInfo.Pid) == "" {
+		return 0, fmt.Errorf("container %s is not running", containerName)
+	}
+	execId, err := container.NewExecID()
 	if err != nil {
-		logrus.Errorf("Exec container getContainerPidByName %s error %v", containerName, err)
-		return
+		return 0, err
+	}
+	session := container.ExecSession{
+		ID:         execId,
+		Cmd:        comArray,
+		Env:        opts.Env,
+		TTY:        opts.TTY,
+		Detach:     opts.Detach,
+		Privileged: opts.Privileged,
+		StartedAt:  time.Now(),
+	}
+	logrus.Infof("container pid %s", containerInfo.Pid)
+	logrus.Infof("command %v", comArray)
+
+	if opts.Detach {
+		if err := addExecSession(containerInfo.Id, session); err != nil {
+			return 0, err
+		}
+		if err := startExecMonitorProcess(containerInfo.Id, execId); err != nil {
+			removeExecSession(containerInfo.Id, execId)
+			return 0, err
+		}
+		return 0, nil
 	}
-	cmdStr := strings.Join(comArray, " ")
-	logrus.Infof("container pid %s", pid)
-	logrus.Infof("command %s", cmdStr)
 
-	// 目的是 nsenter, 进入目标容器的 namespace
-	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := newExecCommand(containerInfo, &session)
+	var tty *ttySession
+	closeSlave := func() {}
+	if opts.TTY {
+		// -ti 时为 exec 的进程分配伪终端
+		tty = newTTYSession()
+		defer tty.Close()
+		if closeSlave, err = tty.attachProcess(cmd); err != nil {
+			return 0, fmt.Errorf("allocate pty error %v", err)
+		}
+	} else {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		closeSlave()
+		return 0, err
+	}
+	closeSlave()
+	session.Pid = cmd.Process.Pid
+	if err := addExecSession(containerInfo.Id, session); err != nil {
+		logrus.Warnf("Record exec session error %v", err)
+	}
+	defer removeExecSession(containerInfo.Id, execId)
 
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
-	containerEnvs := getEnvsByPid(pid)
-	cmd.Env = append(os.Environ(), containerEnvs...)
+	code, err := exitCode(cmd.Wait())
+	if tty != nil {
+		tty.detachProcess()
+	}
+	return code, err
+}
 
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("Exec container %s error %v", containerName, err)
+func startExecMonitorProcess(containerId, execId string) error {
+	cmd := exec.Command("/proc/self/exe", "exec-monitor", containerId, execId)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
 	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
 }
 
-func GetContainerPidByName(containerName string) (string, error) {
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	configFilePath := dirURL + container.ConfigName
-	contentBytes, err := os.ReadFile(configFilePath)
+// exec -d 的后台进程: 执行命令, 把输出写入容器的日志, 命令退出后删除会话记录
+func execMonitor(containerId, execId string) error {
+	defer removeExecSession(containerId, execId)
+	info, err := getContainerInfoById(containerId)
+	if err != nil {
+		return err
+	}
+	var session *container.ExecSession
+	for i := range info.ExecSessions {
+		if info.ExecSessions[i].ID == execId {
+			session = &info.ExecSessions[i]
+		}
+	}
+	if session == nil {
+		return fmt.Errorf("exec session %s not found", execId)
+	}
+
+	logPath := fmt.Sprintf(container.DefaultInfoLocation, containerId) + container.ContainerLogFile
+	containerLogger, err := logger.New(info.LogConfig.Type, logger.Info{
+		ContainerID:   info.Id,
+		ContainerName: info.Name,
+		LogPath:       logPath,
+		Config:        info.LogConfig.Config,
+	})
+	if err != nil {
+		return err
+	}
+	defer containerLogger.Close()
+	// stdout 和 stderr 两个 goroutine 共用一个 Logger
+	var mu sync.Mutex
+	safeLogger := &lockedLogger{Logger: containerLogger, mu: &mu}
+
+	cmd := newExecCommand(info, session)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return "", err
+		return err
 	}
-	var containerInfo container.ContainerInfo
-	if err := json.Unmarshal(contentBytes, &containerInfo); err != nil {
-		return "", err
+	if err := cmd.Start(); err != nil {
+		return err
 	}
-	return containerInfo.Pid, nil
+	_, _ = modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+		for i := range info.ExecSessions {
+			if info.ExecSessions[i].ID == execId {
+				info.ExecSessions[i].Pid = cmd.Process.Pid
+			}
+		}
+	})
+
+	var copiers sync.WaitGroup
+	copyToLog := func(r io.Reader, source string) {
+		defer copiers.Done()
+		lines := logger.NewLineWriter(safeLogger, source)
+		_, _ = io.Copy(lines, r)
+		_ = lines.Close()
+	}
+	copiers.Add(2)
+	go copyToLog(stdout, "stdout")
+	go copyToLog(stderr, "stderr")
+	// 读完所有输出之后才能 Wait, Wait 会关闭管道
+	copiers.Wait()
+	code, err := exitCode(cmd.Wait())
+	logrus.Infof("exec %s in container %s exited with code %d", execId, containerId, code)
+	return err
+}
+
+type lockedLogger struct {
+	logger.Logger
+	mu *sync.Mutex
+}
+
+func (l *lockedLogger) Log(msg *logger.Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Logger.Log(msg)
+}
+
+func addExecSession(containerId string, session container.ExecSession) error {
+	_, err := modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+		info.ExecSessions = append(info.ExecSessions, session)
+	})
+	return err
+}
+
+func removeExecSession(containerId, execId string) {
+	_, _ = modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+		info.RemoveExecSession(execId)
+	})
+}
+
+// 创建执行 exec 会话的命令
+func newExecCommand(info *container.ContainerInfo, session *container.ExecSession) *exec.Cmd {
+	cmd := newNsenterCommand(context.Background(), info.Id, info.Pid, session.Cmd, session.Env)
+	cmd.Env = append(cmd.Env, ENV_EXEC_USER+"="+info.User, ENV_EXEC_WORKDIR+"="+info.WorkingDir)
+	if session.Privileged {
+		cmd.Env = append(cmd.Env, ENV_EXEC_PRIVILEGED+"=1")
+	}
+	return cmd
+}
+
+// nsenter fork 出来的子进程已经在容器的 namespace 和 cgroup 中, 切换用户和工作目录后执行 exec 之后的参数
+// 只有出错时才返回, 和 shell 一样命令不存在时返回 127, 其他错误返回 126
+func execInContainer() int {
+	user, workingDir := os.Getenv(ENV_EXEC_USER), os.Getenv(ENV_EXEC_WORKDIR)
+	for _, key := range []string{ENV_EXEC_PID, ENV_EXEC_CGROUP, ENV_EXEC_PRIVILEGED, ENV_EXEC_USER, ENV_EXEC_WORKDIR} {
+		os.Unsetenv(key)
+	}
+	err := container.RunExecProcess(os.Args[2:], user, workingDir)
+	fmt.Fprintf(os.Stderr, "mydocker exec: %v\n", err)
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return 127
+	}
+	return 126
+}
+
+// 把 cmd.Run/Wait 的错误转换成退出码, 只有进程没能运行时才返回错误
+func exitCode(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
+
+// 目的是 nsenter, 进入目标容器的 namespace 和 cgroup 执行 argv
+// argv 原样放在 /proc/self/exe exec 之后, 不经过 shell, C 构造函数进入 namespace 后由 execInContainer 执行
+// 环境变量为容器 init 进程的环境变量加上 extraEnv, 不继承宿主机上 mydocker 的环境变量
+func newNsenterCommand(ctx context.Context, containerId, pid string, argv []string, extraEnv []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "/proc/self/exe", append([]string{"exec"}, argv...)...)
+	procsFiles := cgroups.NewCgroupManager(containerCgroupPath(containerId)).ProcsFiles()
+	cmd.Env = append(getEnvsByPid(pid), extraEnv...)
+	cmd.Env = append(cmd.Env, ENV_EXEC_PID+"="+pid, ENV_EXEC_CGROUP+"="+strings.Join(procsFiles, ":"))
+	return cmd
 }
 
 func getEnvsByPid(pid string) []string {
@@ -64,6 +273,11 @@ func getEnvsByPid(pid string) []string {
 		return nil
 	}
 	// env split by \u0000
-	envs := strings.Split(string(contentBytes), "\u0000")
+	var envs []string
+	for _, env := range strings.Split(string(contentBytes), "\u0000") {
+		if env != "" {
+			envs = append(envs, env)
+		}
+	}
 	return envs
 }
@@ -0,0 +1,63 @@
+package graphdriver
+
+import (
+	"fmt"
+	"io"
+	"mydocker/archive"
+	"os"
+	"syscall"
+)
+
+func init() {
+	register(AufsDriver, func() Driver { return &aufsDriver{} }, func() bool {
+		return filesystemSupported("aufs")
+	})
+}
+
+// aufs 的可写层直接使用 /root/writeLayer/<id>, 和旧版本的容器保持一致
+type aufsDriver struct{}
+
+func (d *aufsDriver) String() string {
+	return AufsDriver
+}
+
+func (d *aufsDriver) Create(id string, lowers []string) error {
+	return os.MkdirAll(layerHome(id), 0777)
+}
+
+func (d *aufsDriver) Mount(id string, lowers []string, target string) error {
+	// 只有可写层是 rw 的, 镜像层都是只读分支
+	data := "dirs=" + layerHome(id) + "=rw"
+	for _, dir := range lowers {
+		data += ":" + dir + "=ro+wh"
+	}
+	if err := syscall.Mount("none", target, "aufs", 0, data); err != nil {
+		return fmt.Errorf("mount aufs on %s error %v", target, err)
+	}
+	return nil
+}
+
+func (d *aufsDriver) Unmount(target string) error {
+	return unmount(target)
+}
+
+func (d *aufsDriver) Remove(id string) error {
+	return os.RemoveAll(layerHome(id))
+}
+
+func (d *aufsDriver) Changes(id string, lowers []string) ([]archive.Change, error) {
+	return archive.Changes(layerHome(id), lowers)
+}
+
+func (d *aufsDriver) Diff(id string, lowers []string, w io.Writer) error {
+	return archive.TarLayer(layerHome(id), w)
+}
+
+// aufs 会把只读分支中的 .wh. 文件当作 whiteout, 解压后不需要转换
+func (d *aufsDriver) ApplyDiff(dir string, diff io.Reader) error {
+	return untar(dir, diff, nil)
+}
+
+func (d *aufsDriver) Status() [][2]string {
+	return status()
+}
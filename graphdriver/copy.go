@@ -0,0 +1,209 @@
+package graphdriver
+
+import (
+	"io"
+	"mydocker/archive"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// 把 .wh. 格式的镜像层 layer 叠加到 dst 上
+// 先处理 whiteout 删除下层的文件, 再复制这一层的文件, 保留属主, 权限, xattr, 修改时间和硬链接
+func applyLayer(dst, layer string) error {
+	err := filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if !strings.HasPrefix(name, archive.WhiteoutPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil {
+			return err
+		}
+		// 下层的符号链接可能指向宿主机上的目录, 经过符号链接的 whiteout 会删除宿主机的文件
+		// 这一层会用目录替换掉这个符号链接, 符号链接下的文件本来就看不到, 直接跳过
+		if err := archive.CheckParents(dst, filepath.ToSlash(rel)); err != nil {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, filepath.Dir(rel))
+		switch {
+		case name == archive.WhiteoutOpaqueDir:
+			// 下层目录的内容全部隐藏
+			entries, err := os.ReadDir(target)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, entry := range entries {
+				if err := os.RemoveAll(filepath.Join(target, entry.Name())); err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(name, archive.WhiteoutMetaPrefix):
+			// aufs 自己的元数据
+		default:
+			if err := os.RemoveAll(filepath.Join(target, strings.TrimPrefix(name, archive.WhiteoutPrefix))); err != nil {
+				return err
+			}
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 目录的修改时间在复制子文件时会变化, 最后再设置
+	var dirs []string
+	inodes := map[uint64]string{}
+	err = filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), archive.WhiteoutPrefix) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if rel == "." {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && info.IsDir()) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		stat := info.Sys().(*syscall.Stat_t)
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+				return err
+			}
+			dirs = append(dirs, path)
+			return nil
+		case mode.IsRegular():
+			if first, ok := inodes[stat.Ino]; ok {
+				return os.Link(first, target)
+			}
+			if err := copyRegular(path, target); err != nil {
+				return err
+			}
+			if stat.Nlink > 1 {
+				inodes[stat.Ino] = target
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			// 设备文件, 管道和 socket
+			if err := syscall.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return err
+			}
+		}
+		return copyMetadata(path, target, info)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, _ := filepath.Rel(layer, dirs[i])
+		info, err := os.Lstat(dirs[i])
+		if err != nil {
+			return err
+		}
+		if err := copyMetadata(dirs[i], filepath.Join(dst, rel), info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyRegular(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// chown 会清除 setuid 位和 security.capability, 所以最先执行
+func copyMetadata(src, dst string, info os.FileInfo) error {
+	stat := info.Sys().(*syscall.Stat_t)
+	if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+		return err
+	}
+	if err := copyXattrs(src, dst); err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if err := syscall.Chmod(dst, stat.Mode&07777); err != nil {
+			return err
+		}
+	}
+	times := []unix.Timespec{
+		unix.NsecToTimespec(syscall.TimespecToNsec(stat.Atim)),
+		unix.NsecToTimespec(syscall.TimespecToNsec(stat.Mtim)),
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// 文件系统不支持 xattr 时忽略
+func copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(src, buf); err != nil {
+		return nil
+	}
+	for _, key := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if key == "" {
+			continue
+		}
+		valueSize, err := unix.Lgetxattr(src, key, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, valueSize)
+		if _, err := unix.Lgetxattr(src, key, value); err != nil {
+			continue
+		}
+		if err := unix.Lsetxattr(dst, key, value, 0); err != nil && err != unix.ENOTSUP {
+			return err
+		}
+	}
+	return nil
+}
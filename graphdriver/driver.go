@@ -0,0 +1,180 @@
+package graphdriver
+
+import (
+	"fmt"
+	"io"
+	"mydocker/archive"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	AufsDriver     = "aufs"
+	Overlay2Driver = "overlay2"
+	// 直接复制镜像层, 不需要联合文件系统, 适合嵌套或没有权限挂载 overlay 的环境
+	VfsDriver = "vfs"
+)
+
+// 容器可写层的目录, 每个容器一个子目录
+const Home = "/root/writeLayer"
+
+// 没有指定存储驱动时按顺序选择第一个可用的
+var priority = []string{Overlay2Driver, AufsDriver, VfsDriver}
+
+// 存储驱动负责把镜像层和容器的可写层组合成容器的根文件系统
+// lowers 为镜像各层解压后的目录, 从最上层到最底层排列, 由 ApplyDiff 解压得到
+type Driver interface {
+	// 驱动名, 记录在容器信息中
+	String() string
+	// 为容器 id 创建可写层, 已经存在时直接返回, 容器重启时可写层中的修改需要保留
+	Create(id string, lowers []string) error
+	// 把镜像层和容器的可写层挂载到 target 作为容器的根文件系统
+	Mount(id string, lowers []string, target string) error
+	Unmount(target string) error
+	// 删除容器的可写层
+	Remove(id string) error
+	// 容器相对于镜像的修改
+	Changes(id string, lowers []string) ([]archive.Change, error)
+	// 把容器相对于镜像的修改打包成一个镜像层写入 w
+	Diff(id string, lowers []string, w io.Writer) error
+	// 把镜像层 tar 包解压到 dir, whiteout 转换成驱动使用的格式
+	ApplyDiff(dir string, diff io.Reader) error
+	// 驱动的状态信息, 每一项为名称和值
+	Status() [][2]string
+}
+
+type driver struct {
+	create    func() Driver
+	supported func() bool
+}
+
+var drivers = map[string]driver{}
+
+// 注册存储驱动, 由各个驱动在 init 中调用
+func register(name string, create func() Driver, supported func() bool) {
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("storage driver %s already registered", name))
+	}
+	drivers[name] = driver{create: create, supported: supported}
+}
+
+// 所有已注册的存储驱动名
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 创建 name 对应的存储驱动, name 为空时自动选择
+func New(name string) (Driver, error) {
+	if name == "" {
+		return Default()
+	}
+	d, ok := drivers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %s, available drivers: %v", name, Drivers())
+	}
+	if !d.supported() {
+		return nil, fmt.Errorf("storage driver %s is not supported on this host", name)
+	}
+	return d.create(), nil
+}
+
+// 按 overlay2, aufs, vfs 的顺序选择第一个可用的存储驱动
+func Default() (Driver, error) {
+	for _, name := range priority {
+		if d, ok := drivers[name]; ok && d.supported() {
+			return d.create(), nil
+		}
+	}
+	return nil, fmt.Errorf("no storage driver is supported on this host")
+}
+
+// 容器 id 的可写层目录
+func layerHome(id string) string {
+	return filepath.Join(Home, id)
+}
+
+// 通过 /proc/filesystems 判断内核是否支持某种文件系统
+func filesystemSupported(fsType string) bool {
+	content, err := os.ReadFile("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[len(fields)-1] == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+func unmount(target string) error {
+	if err := syscall.Unmount(target, 0); err != nil {
+		return fmt.Errorf("unmount %s error %v", target, err)
+	}
+	return nil
+}
+
+// 解压镜像层 tar 包到 dir, 先解压到同一目录下的临时目录, 成功后再重命名为 dir
+// 解压失败不会留下不完整的 dir, 下次使用时会重新解压
+func untar(dir string, diff io.Reader, opts *archive.UntarOptions) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := archive.Untar(diff, tmp, opts); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		// 同时运行的其他容器已经解压好了
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// 可写层所在文件系统的类型, 显示在 Status 中
+func backingFilesystem() string {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Dir(Home), &stat); err != nil {
+		return "unknown"
+	}
+	names := map[int64]string{
+		0xEF53:     "extfs",
+		0x58465342: "xfs",
+		0x9123683E: "btrfs",
+		0x01021994: "tmpfs",
+		0x794c7630: "overlayfs",
+		0x61756673: "aufs",
+	}
+	if name, ok := names[int64(stat.Type)]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (0x%x)", stat.Type)
+}
+
+func status() [][2]string {
+	return [][2]string{
+		{"Root Dir", Home},
+		{"Backing Filesystem", backingFilesystem()},
+	}
+}
@@ -0,0 +1,77 @@
+package graphdriver
+
+import (
+	"fmt"
+	"io"
+	"mydocker/archive"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	register(Overlay2Driver, func() Driver { return &overlay2Driver{} }, func() bool {
+		return filesystemSupported("overlay")
+	})
+}
+
+// overlay 的 upperdir 和 workdir 需要在同一个文件系统上
+// 分别放在 /root/writeLayer/<id>/diff 和 /root/writeLayer/<id>/work
+type overlay2Driver struct{}
+
+func (d *overlay2Driver) String() string {
+	return Overlay2Driver
+}
+
+func upperDir(id string) string {
+	return filepath.Join(layerHome(id), "diff")
+}
+
+func workDir(id string) string {
+	return filepath.Join(layerHome(id), "work")
+}
+
+func (d *overlay2Driver) Create(id string, lowers []string) error {
+	for _, dir := range []string{upperDir(id), workDir(id)} {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *overlay2Driver) Mount(id string, lowers []string, target string) error {
+	// lowerdir 中靠左的层在上面, 和 lowers 的顺序一致
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lowers, ":"), upperDir(id), workDir(id))
+	if err := syscall.Mount("overlay", target, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mount overlay on %s error %v", target, err)
+	}
+	return nil
+}
+
+func (d *overlay2Driver) Unmount(target string) error {
+	return unmount(target)
+}
+
+func (d *overlay2Driver) Remove(id string) error {
+	return os.RemoveAll(layerHome(id))
+}
+
+func (d *overlay2Driver) Changes(id string, lowers []string) ([]archive.Change, error) {
+	return archive.Changes(upperDir(id), lowers)
+}
+
+func (d *overlay2Driver) Diff(id string, lowers []string, w io.Writer) error {
+	return archive.TarLayer(upperDir(id), w)
+}
+
+// .wh. 文件转换为 overlay 使用的字符设备和 opaque xattr
+func (d *overlay2Driver) ApplyDiff(dir string, diff io.Reader) error {
+	return untar(dir, diff, &archive.UntarOptions{OverlayWhiteouts: true})
+}
+
+func (d *overlay2Driver) Status() [][2]string {
+	return status()
+}
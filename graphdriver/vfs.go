@@ -0,0 +1,84 @@
+package graphdriver
+
+import (
+	"fmt"
+	"io"
+	"mydocker/archive"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func init() {
+	register(VfsDriver, func() Driver { return &vfsDriver{} }, func() bool { return true })
+}
+
+// vfs 把镜像各层依次复制到 /root/writeLayer/<id>/rootfs, 再 bind mount 到挂载点
+// 不需要内核支持联合文件系统, 代价是每个容器都有一份完整的根文件系统
+type vfsDriver struct{}
+
+func (d *vfsDriver) String() string {
+	return VfsDriver
+}
+
+func rootfsDir(id string) string {
+	return filepath.Join(layerHome(id), "rootfs")
+}
+
+func (d *vfsDriver) Create(id string, lowers []string) error {
+	rootfs := rootfsDir(id)
+	if _, err := os.Stat(rootfs); err == nil {
+		return nil
+	}
+	// 先复制到临时目录, 复制完成后再改名, 失败时不会留下不完整的根文件系统
+	tmp := rootfs + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	for i := len(lowers) - 1; i >= 0; i-- {
+		if err := applyLayer(tmp, lowers[i]); err != nil {
+			os.RemoveAll(tmp)
+			return fmt.Errorf("copy layer %s error %v", lowers[i], err)
+		}
+	}
+	return os.Rename(tmp, rootfs)
+}
+
+func (d *vfsDriver) Mount(id string, lowers []string, target string) error {
+	if err := syscall.Mount(rootfsDir(id), target, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount %s on %s error %v", rootfsDir(id), target, err)
+	}
+	return nil
+}
+
+func (d *vfsDriver) Unmount(target string) error {
+	return unmount(target)
+}
+
+func (d *vfsDriver) Remove(id string) error {
+	return os.RemoveAll(layerHome(id))
+}
+
+func (d *vfsDriver) Changes(id string, lowers []string) ([]archive.Change, error) {
+	return archive.ChangesDirs(rootfsDir(id), lowers)
+}
+
+func (d *vfsDriver) Diff(id string, lowers []string, w io.Writer) error {
+	changes, err := d.Changes(id, lowers)
+	if err != nil {
+		return err
+	}
+	return archive.ExportChanges(rootfsDir(id), changes, w)
+}
+
+// 和 aufs 一样保留 .wh. 文件, 复制镜像层时再处理
+func (d *vfsDriver) ApplyDiff(dir string, diff io.Reader) error {
+	return untar(dir, diff, nil)
+}
+
+func (d *vfsDriver) Status() [][2]string {
+	return status()
+}
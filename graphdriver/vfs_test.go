@@ -0,0 +1,122 @@
+package graphdriver
+
+import (
+	"mydocker/archive"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files ...string) {
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if f[len(f)-1] == '/' {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(f), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 两层镜像: 上层删除了 etc/hosts, 清空了 opt, 修改了 etc/passwd
+func testLayers(t *testing.T) []string {
+	lower := t.TempDir()
+	upper := t.TempDir()
+	writeFiles(t, lower, "etc/passwd", "etc/hosts", "opt/a", "bin/sh")
+	if err := os.Link(filepath.Join(lower, "bin/sh"), filepath.Join(lower, "bin/ash")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, upper, "etc/.wh.hosts", "etc/passwd", "opt/.wh..wh..opq", "opt/b", ".wh..wh.plnk/1")
+	return []string{upper, lower}
+}
+
+func TestApplyLayers(t *testing.T) {
+	layers := testLayers(t)
+	rootfs := t.TempDir()
+	for i := len(layers) - 1; i >= 0; i-- {
+		if err := applyLayer(rootfs, layers[i]); err != nil {
+			t.Fatalf("apply layer error %v", err)
+		}
+	}
+	for _, f := range []string{"etc/hosts", "opt/a", ".wh..wh.plnk", "etc/.wh.hosts", "opt/.wh..wh..opq"} {
+		if _, err := os.Lstat(filepath.Join(rootfs, f)); err == nil {
+			t.Fatalf("%s should not exist", f)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(rootfs, "etc/passwd")); string(content) != "etc/passwd" {
+		t.Fatalf("etc/passwd not copied from upper layer: %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "opt/b")); err != nil {
+		t.Fatalf("opt/b missing: %v", err)
+	}
+	sh, _ := os.Stat(filepath.Join(rootfs, "bin/sh"))
+	ash, _ := os.Stat(filepath.Join(rootfs, "bin/ash"))
+	if sh == nil || ash == nil || sh.Sys().(*syscall.Stat_t).Ino != ash.Sys().(*syscall.Stat_t).Ino {
+		t.Fatalf("hard link not preserved")
+	}
+
+	// 没有修改时和镜像没有差别
+	changes, err := archive.ChangesDirs(rootfs, layers)
+	if err != nil {
+		t.Fatalf("changes error %v", err)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expect no changes, got %v", changes)
+	}
+
+	writeFiles(t, rootfs, "etc/passwd", "tmp/new")
+	if err := os.Chmod(filepath.Join(rootfs, "etc/passwd"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(rootfs, "bin")); err != nil {
+		t.Fatal(err)
+	}
+	changes, err = archive.ChangesDirs(rootfs, layers)
+	if err != nil {
+		t.Fatalf("changes error %v", err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	expect := []string{"D /bin", "C /etc", "C /etc/passwd", "A /tmp", "A /tmp/new"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expect %v, got %v", expect, got)
+	}
+}
+
+// 下层的符号链接指向 rootfs 之外, 上层经过这个符号链接的 whiteout 不能删除外面的文件
+func TestApplyLayerWhiteoutThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	writeFiles(t, outside, "shadow", "opt/a")
+	lower := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(lower, "etc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "opt"), filepath.Join(lower, "opt")); err != nil {
+		t.Fatal(err)
+	}
+	upper := t.TempDir()
+	writeFiles(t, upper, "etc/.wh.shadow", "opt/.wh..wh..opq")
+
+	rootfs := t.TempDir()
+	for _, layer := range []string{lower, upper} {
+		if err := applyLayer(rootfs, layer); err != nil {
+			t.Fatalf("apply layer error %v", err)
+		}
+	}
+	for _, f := range []string{"shadow", "opt/a"} {
+		if _, err := os.Stat(filepath.Join(outside, f)); err != nil {
+			t.Fatalf("%s outside rootfs was removed: %v", f, err)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(rootfs, "etc")); err != nil || !info.IsDir() {
+		t.Fatalf("etc should be replaced by the upper layer's directory: %v", err)
+	}
+}
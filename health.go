@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mydocker/container"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 按照 Healthcheck 配置周期性地在容器内执行检查命令, 直到 stop 被关闭
+func runHealthcheck(containerId, pid string, config *container.HealthConfig, stop <-chan struct{}) {
+	startTime := time.Now()
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		result := probeContainer(containerId, pid, config)
+		inStartPeriod := time.Since(startTime) < config.StartPeriod
+		_, err := modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+			if info.Health == nil {
+				info.Health = &container.Health{Status: container.HealthStarting}
+			}
+			info.Health.Update(result, config.Retries, inStartPeriod)
+		})
+		if err != nil {
+			logrus.Errorf("Update container %s health error %v", containerId, err)
+		}
+	}
+}
+
+// 通过和 mydocker exec 相同的 nsenter 方式在容器内执行一次检查命令
+func probeContainer(containerId, pid string, config *container.HealthConfig) *container.HealthcheckResult {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	// 和 docker 的 CMD-SHELL 一样, 检查命令交给容器内的 /bin/sh 执行
+	cmd := newNsenterCommand(ctx, containerId, pid, []string{"/bin/sh", "-c", config.Cmd}, nil)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// 检查命令由 nsenter fork 出来, 超时时需要杀掉整个进程组
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	result := &container.HealthcheckResult{Start: time.Now()}
+	err := cmd.Run()
+	result.End = time.Now()
+	result.ExitCode = 0
+	if err != nil {
+		result.ExitCode = 1
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			result.ExitCode = exitErr.ExitCode()
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		result.ExitCode = -1
+		output.WriteString("Health check exceeded timeout (" + config.Timeout.String() + ")")
+	}
+	result.Output = output.String()
+	if len(result.Output) > container.MaxHealthOutputLen {
+		result.Output = result.Output[:container.MaxHealthOutputLen]
+	}
+	return result
+}
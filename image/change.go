@@ -0,0 +1,80 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// 应用 commit --change 传入的 Dockerfile 指令, 目前支持 CMD, ENV 和 WORKDIR
+func ApplyChanges(config *Config, changes []string) error {
+	for _, change := range changes {
+		instruction, args, _ := strings.Cut(strings.TrimSpace(change), " ")
+		args = strings.TrimSpace(args)
+		if args == "" {
+			return fmt.Errorf("%s requires at least one argument", instruction)
+		}
+		switch strings.ToUpper(instruction) {
+		case "CMD":
+			config.Cmd = parseCommand(args)
+		case "ENV":
+			env, err := parseEnvInstruction(args)
+			if err != nil {
+				return err
+			}
+			for _, kv := range env {
+				config.Env = setEnv(config.Env, kv)
+			}
+		case "WORKDIR":
+			// 相对路径相对于之前的工作目录
+			if !filepath.IsAbs(args) {
+				args = filepath.Join("/", config.WorkingDir, args)
+			}
+			config.WorkingDir = filepath.Clean(args)
+		default:
+			return fmt.Errorf("unsupported change instruction %s, only CMD, ENV and WORKDIR are supported", instruction)
+		}
+	}
+	return nil
+}
+
+// JSON 数组形式 ["a", "b"] 直接作为 argv, 否则交给 /bin/sh -c 执行
+func parseCommand(args string) []string {
+	var argv []string
+	if strings.HasPrefix(args, "[") && json.Unmarshal([]byte(args), &argv) == nil {
+		return argv
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// ENV KEY=VALUE [KEY2=VALUE2 ...] 或者 ENV KEY VALUE
+func parseEnvInstruction(args string) ([]string, error) {
+	fields := strings.Fields(args)
+	if !strings.Contains(fields[0], "=") {
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("ENV %s requires a value", fields[0])
+		}
+		key := fields[0]
+		value := strings.TrimSpace(strings.TrimPrefix(args, key))
+		return []string{key + "=" + value}, nil
+	}
+	for _, field := range fields {
+		if !strings.Contains(field, "=") || strings.HasPrefix(field, "=") {
+			return nil, fmt.Errorf("invalid ENV %s, must be KEY=VALUE", field)
+		}
+	}
+	return fields, nil
+}
+
+// 设置环境变量, 已有的同名变量被覆盖
+func setEnv(env []string, kv string) []string {
+	key := strings.SplitN(kv, "=", 2)[0]
+	for i, e := range env {
+		if strings.SplitN(e, "=", 2)[0] == key {
+			env[i] = kv
+			return env
+		}
+	}
+	return append(env, kv)
+}
@@ -0,0 +1,34 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyChanges(t *testing.T) {
+	config := &Config{Env: []string{DefaultPathEnv, "FOO=old"}, WorkingDir: "/app"}
+	err := ApplyChanges(config, []string{
+		`CMD ["nginx", "-g", "daemon off;"]`,
+		"ENV FOO=new BAR=1",
+		"ENV GREETING hello world",
+		"WORKDIR sub",
+	})
+	if err != nil {
+		t.Fatalf("apply changes error %v", err)
+	}
+	expect := &Config{
+		Env:        []string{DefaultPathEnv, "FOO=new", "BAR=1", "GREETING=hello world"},
+		Cmd:        []string{"nginx", "-g", "daemon off;"},
+		WorkingDir: "/app/sub",
+	}
+	if !reflect.DeepEqual(config, expect) {
+		t.Fatalf("expect %+v, got %+v", expect, config)
+	}
+
+	if err := ApplyChanges(config, []string{"CMD top -b"}); err != nil || !reflect.DeepEqual(config.Cmd, []string{"/bin/sh", "-c", "top -b"}) {
+		t.Fatalf("shell form cmd: %v %v", config.Cmd, err)
+	}
+	if err := ApplyChanges(config, []string{"EXPOSE 80"}); err == nil {
+		t.Fatalf("expect error for unsupported instruction")
+	}
+}
@@ -0,0 +1,106 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"mydocker/store"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 镜像没有配置文件时使用的默认环境变量
+const DefaultPathEnv = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// 镜像和镜像层所在的目录
+var RootUrl = "/root"
+
+// 镜像的配置, 以内容的 sha256 作为镜像 ID 保存在 /root/imagedb 下
+type Config struct {
+	Env          []string            `json:"env"`          // 容器启动时的默认环境变量
+	Entrypoint   []string            `json:"entrypoint"`   // 启动命令的前缀, Cmd 作为它的参数
+	Cmd          []string            `json:"cmd"`          // 默认的启动命令
+	WorkingDir   string              `json:"workingDir"`   // 默认的工作目录
+	User         string              `json:"user"`         // 运行容器进程的用户, user[:group] 或 uid[:gid]
+	ExposedPorts map[string]struct{} `json:"exposedPorts"` // 镜像声明的端口, 例如 80/tcp
+	StopSignal   string              `json:"stopSignal"`   // mydocker stop 发送的信号, 为空时使用 SIGTERM
+	Author       string              `json:"author"`
+	Comment      string              `json:"comment"` // commit -m 的说明
+	Created      time.Time           `json:"created"`
+	Parent       string              `json:"parent"` // commit 时容器所用镜像的 ID
+	// 镜像层 tar 包的 sha256, 从最底层到最上层排列
+	Layers []string `json:"layers"`
+}
+
+// 镜像声明的端口, 按字符串排序
+func (c *Config) ExposedPortList() []string {
+	var ports []string
+	for port := range c.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+// 旧版本的镜像直接放在 RootUrl 下, 例如 /root/busybox.tar 和 commit 生成的 /root/busybox.json
+func legacyConfigPath(name string) string {
+	return fmt.Sprintf("%s/%s.json", RootUrl, name)
+}
+
+func legacyTarPath(name string) string {
+	return fmt.Sprintf("%s/%s.tar", RootUrl, name)
+}
+
+func legacyExists(name string) bool {
+	for _, path := range []string{legacyConfigPath(name), legacyTarPath(name)} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// 把旧版本的镜像导入镜像存储, 打上 name:latest
+// 旧的配置文件中 Layers 是相对于 RootUrl 的 tar 包路径, 没有配置文件时只有 <name>.tar 一层
+func importLegacy(name string) (string, error) {
+	l, err := lock()
+	if err != nil {
+		return "", err
+	}
+	defer l.Unlock()
+	// 可能已经被其他进程导入了
+	repos, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	ref := name + ":" + DefaultTag
+	if id, ok := repos[ref]; ok {
+		return id, nil
+	}
+
+	config := &Config{Env: []string{DefaultPathEnv}}
+	if err := store.ReadJSON(legacyConfigPath(name), config); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("read image config %s error %v", legacyConfigPath(name), err)
+	}
+	paths := config.Layers
+	if len(paths) == 0 {
+		paths = []string{name + ".tar"}
+	}
+	var layers []LayerWriter
+	for _, path := range paths {
+		path := filepath.Join(RootUrl, path)
+		layers = append(layers, func(w io.Writer) error {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		})
+	}
+	config.Layers = nil
+	config.Parent = ""
+	return createImage(config, layers, []string{ref})
+}
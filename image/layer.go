@@ -0,0 +1,54 @@
+package image
+
+import (
+	"fmt"
+	"mydocker/graphdriver"
+	"os"
+	"path/filepath"
+)
+
+// 镜像层解压后的目录, 不同存储驱动的 whiteout 格式不同, 各自解压一份
+// aufs 解压到 /root/layers/<sha256>, 其他驱动解压到 /root/layers/<sha256>.<driver>
+func layerDir(layer string, driver graphdriver.Driver) string {
+	dir := filepath.Join(RootUrl, LayersDir, digestHex(layer))
+	if driver.String() != graphdriver.AufsDriver {
+		dir += "." + driver.String()
+	}
+	return dir
+}
+
+// 返回镜像所有层解压后的目录, 从最上层到最底层排列, 可以直接交给存储驱动使用
+// 还没有解压的层先由存储驱动解压
+func LayerDirs(ref string, driver graphdriver.Driver) ([]string, error) {
+	_, config, err := LoadConfig(ref)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(config.Layers))
+	for i := len(config.Layers) - 1; i >= 0; i-- {
+		dir, err := extractLayer(config.Layers[i], driver)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// 解压镜像层, 目录已经存在时认为已经解压过, 存储驱动保证解压失败时不会留下目录
+func extractLayer(layer string, driver graphdriver.Driver) (string, error) {
+	dir := layerDir(layer, driver)
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+	tarPath := LayerPath(layer)
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := driver.ApplyDiff(dir, f); err != nil {
+		return "", fmt.Errorf("apply layer %s error %v", tarPath, err)
+	}
+	return dir, nil
+}
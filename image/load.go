@@ -0,0 +1,356 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"mydocker/archive"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// 导入的一个镜像
+type LoadedImage struct {
+	ID   string
+	Refs []string
+}
+
+// 导入 docker save 或 OCI image-layout 格式的归档, 归档本身可以是 gzip 压缩的
+// 镜像层按顺序写入镜像存储, 校验 blob 和 diff_ids 的摘要, 镜像层中的 .wh. 文件由存储驱动解压时处理
+// 新版本 docker save 的归档同时包含两种格式, 优先使用带有 RepoTags 的 manifest.json
+func Load(r io.Reader) ([]LoadedImage, error) {
+	if err := os.MkdirAll(RootUrl, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(RootUrl, ".load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	a, err := unpackArchive(r, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read archive error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, dockerManifests)); err == nil {
+		return a.loadDockerArchive()
+	}
+	if _, err := os.Stat(filepath.Join(dir, ociIndexFile)); err == nil {
+		return a.loadOCILayout()
+	}
+	return nil, fmt.Errorf("invalid archive: neither %s nor %s found", dockerManifests, ociIndexFile)
+}
+
+// 解压到临时目录的归档, 旧版本 docker save 用符号链接表示重复的镜像层, 读取时在归档内部解析
+type unpacked struct {
+	dir   string
+	links map[string]string
+}
+
+// 只解压普通文件和目录, 符号链接只记录下来, 不会在宿主机上创建
+func unpackArchive(r io.Reader, dir string) (*unpacked, error) {
+	stream, err := archive.DecompressStream(r)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	a := &unpacked{dir: dir, links: map[string]string{}}
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return a, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, err := archive.CleanPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			a.links[name] = path.Join(path.Dir(name), hdr.Linkname)
+		}
+	}
+}
+
+func (a *unpacked) open(name string) (*os.File, error) {
+	name, err := archive.CleanPath(name)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 10; i++ {
+		target, ok := a.links[name]
+		if !ok {
+			return os.Open(filepath.Join(a.dir, name))
+		}
+		if name, err = archive.CleanPath(target); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("too many levels of symbolic links in archive")
+}
+
+// 读取 JSON 文件, digest 不为空时校验内容的摘要
+func (a *unpacked) readJSON(name, digest string, v interface{}) error {
+	f, err := a.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if digest != "" {
+		sum := sha256.Sum256(data)
+		if actual := DigestPrefix + hex.EncodeToString(sum[:]); actual != digest {
+			return fmt.Errorf("%s: digest mismatch, expected %s, got %s", name, digest, actual)
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parse %s error %v", name, err)
+	}
+	return nil
+}
+
+// OCI blob 的路径, 只支持 sha256
+func blobPath(digest string) (string, error) {
+	h := strings.TrimPrefix(digest, DigestPrefix)
+	if !strings.HasPrefix(digest, DigestPrefix) || !hexRegexp.MatchString(h) {
+		return "", fmt.Errorf("unsupported digest %s", digest)
+	}
+	return path.Join(ociBlobsDir, "sha256", h), nil
+}
+
+// 如果路径是 blobs/sha256/<hex>, 文件名就是内容的摘要
+func digestFromPath(name string) string {
+	dir, base := path.Split(path.Clean(name))
+	if path.Clean(dir) == path.Join(ociBlobsDir, "sha256") && hexRegexp.MatchString(base) {
+		return DigestPrefix + base
+	}
+	return ""
+}
+
+// 按 diff_ids 校验镜像层, 压缩的镜像层先解压, 写入镜像存储的是未压缩的 tar 包
+// blobDigest 不为空时同时校验归档中的原始文件
+func (a *unpacked) layerWriter(name, blobDigest, diffID string) LayerWriter {
+	return func(w io.Writer) error {
+		f, err := a.open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		blobHash := sha256.New()
+		stream, err := archive.DecompressStream(io.TeeReader(f, blobHash))
+		if err != nil {
+			return fmt.Errorf("layer %s: %v", name, err)
+		}
+		defer stream.Close()
+		diffHash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(w, diffHash), stream); err != nil {
+			return fmt.Errorf("layer %s: %v", name, err)
+		}
+		if actual := hashDigest(diffHash); actual != diffID {
+			return fmt.Errorf("layer %s: diff id mismatch, expected %s, got %s", name, diffID, actual)
+		}
+		if blobDigest != "" {
+			// 压缩数据后面可能还有没读到的部分
+			if _, err := io.Copy(blobHash, f); err != nil {
+				return err
+			}
+			if actual := hashDigest(blobHash); actual != blobDigest {
+				return fmt.Errorf("layer %s: digest mismatch, expected %s, got %s", name, blobDigest, actual)
+			}
+		}
+		return nil
+	}
+}
+
+func hashDigest(h hash.Hash) string {
+	return DigestPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// 按 docker save 的 manifest.json 导入
+func (a *unpacked) loadDockerArchive() ([]LoadedImage, error) {
+	var manifests []dockerManifest
+	if err := a.readJSON(dockerManifests, "", &manifests); err != nil {
+		return nil, err
+	}
+	var loaded []LoadedImage
+	for _, m := range manifests {
+		// 旧格式的配置文件名为 <sha256>.json, 新格式在 blobs/sha256 下
+		configDigest := digestFromPath(m.Config)
+		if base := path.Base(m.Config); configDigest == "" && hexRegexp.MatchString(strings.TrimSuffix(base, ".json")) {
+			configDigest = DigestPrefix + strings.TrimSuffix(base, ".json")
+		}
+		var layers []layerRef
+		for _, layer := range m.Layers {
+			layers = append(layers, layerRef{name: layer, blobDigest: digestFromPath(layer)})
+		}
+		image, err := a.createImage(m.Config, configDigest, layers, m.RepoTags)
+		if err != nil {
+			return loaded, err
+		}
+		loaded = append(loaded, image)
+	}
+	return loaded, nil
+}
+
+// 按 OCI image-layout 的 index.json 导入
+func (a *unpacked) loadOCILayout() ([]LoadedImage, error) {
+	var index ociIndex
+	if err := a.readJSON(ociIndexFile, "", &index); err != nil {
+		return nil, err
+	}
+	var loaded []LoadedImage
+	for _, desc := range index.Manifests {
+		manifest, err := a.resolveManifest(desc, 0)
+		if err != nil {
+			return loaded, err
+		}
+		configPath, err := blobPath(manifest.Config.Digest)
+		if err != nil {
+			return loaded, err
+		}
+		var layers []layerRef
+		for _, layer := range manifest.Layers {
+			name, err := blobPath(layer.Digest)
+			if err != nil {
+				return loaded, err
+			}
+			layers = append(layers, layerRef{name: name, blobDigest: layer.Digest})
+		}
+		image, err := a.createImage(configPath, manifest.Config.Digest, layers, ociRefs(desc))
+		if err != nil {
+			return loaded, err
+		}
+		loaded = append(loaded, image)
+	}
+	return loaded, nil
+}
+
+// 多平台镜像的 index 中选择和当前平台一致的 manifest, 没有时选择第一个
+func (a *unpacked) resolveManifest(desc ociDescriptor, depth int) (*ociManifest, error) {
+	if depth > 4 {
+		return nil, fmt.Errorf("too many levels of nested index")
+	}
+	name, err := blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType != mediaTypeOCIIndex && desc.MediaType != mediaTypeDockerList {
+		manifest := &ociManifest{}
+		if err := a.readJSON(name, desc.Digest, manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+	var index ociIndex
+	if err := a.readJSON(name, desc.Digest, &index); err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) == 0 {
+		return nil, fmt.Errorf("index %s has no manifests", desc.Digest)
+	}
+	chosen := index.Manifests[0]
+	for _, m := range index.Manifests {
+		if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+			chosen = m
+			break
+		}
+	}
+	return a.resolveManifest(chosen, depth+1)
+}
+
+// index.json 中记录的镜像名, ref.name 只有 tag 时无法确定镜像名, 导入为没有 tag 的镜像
+func ociRefs(desc ociDescriptor) []string {
+	if name := desc.Annotations[annotationImageName]; name != "" {
+		return []string{name}
+	}
+	if ref := desc.Annotations[annotationRefName]; strings.ContainsAny(ref, ":/") {
+		return []string{ref}
+	}
+	return nil
+}
+
+type layerRef struct {
+	name       string
+	blobDigest string
+}
+
+// 读取并校验镜像配置, 按顺序写入镜像层, 打上 refs 中合法的 tag
+func (a *unpacked) createImage(configName, configDigest string, layers []layerRef, refs []string) (LoadedImage, error) {
+	var oci ociImageConfig
+	if err := a.readJSON(configName, configDigest, &oci); err != nil {
+		return LoadedImage{}, err
+	}
+	if len(oci.RootFS.DiffIDs) != len(layers) {
+		return LoadedImage{}, fmt.Errorf("%s: %d diff_ids for %d layers", configName, len(oci.RootFS.DiffIDs), len(layers))
+	}
+	var writers []LayerWriter
+	for i, layer := range layers {
+		writers = append(writers, a.layerWriter(layer.name, layer.blobDigest, oci.RootFS.DiffIDs[i]))
+	}
+	var names []string
+	for _, ref := range refs {
+		if name := familiarName(ref); name != "" {
+			names = append(names, name)
+		}
+	}
+	id, err := CreateImage(fromOCIConfig(&oci), writers, names...)
+	if err != nil {
+		return LoadedImage{}, err
+	}
+	var normalized []string
+	for _, name := range names {
+		n, _ := NormalizeReference(name)
+		normalized = append(normalized, n)
+	}
+	return LoadedImage{ID: id, Refs: normalized}, nil
+}
+
+// docker.io/library/busybox:latest 简写为 busybox:latest, 不合法的镜像名返回空
+func familiarName(ref string) string {
+	for _, prefix := range []string{"docker.io/library/", "index.docker.io/library/", "docker.io/", "index.docker.io/"} {
+		if strings.HasPrefix(ref, prefix) {
+			ref = strings.TrimPrefix(ref, prefix)
+			break
+		}
+	}
+	// 带有 digest 的引用不能作为 tag
+	if strings.Contains(ref, "@") {
+		return ""
+	}
+	if _, err := NormalizeReference(ref); err != nil {
+		return ""
+	}
+	return ref
+}
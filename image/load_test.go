@@ -0,0 +1,157 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func layerTar(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return DigestPrefix + hex.EncodeToString(sum[:])
+}
+
+type archiveEntry struct {
+	name, content, link string
+}
+
+func buildArchive(t *testing.T, entries []archiveEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: e.name, Mode: 0644, Size: int64(len(e.content))}
+		if e.link != "" {
+			hdr = &tar.Header{Typeflag: tar.TypeSymlink, Name: e.name, Linkname: e.link}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+	return &buf
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	RootUrl = t.TempDir()
+	layer := layerTar(t, map[string]string{"etc/motd": "hello", "etc/.wh.hosts": ""})
+	config := &Config{
+		Env:          []string{DefaultPathEnv},
+		Entrypoint:   []string{"/bin/sh", "-c"},
+		Cmd:          []string{"echo hi"},
+		WorkingDir:   "/app",
+		User:         "1000:1000",
+		ExposedPorts: map[string]struct{}{"80/tcp": {}},
+		Created:      time.Unix(100, 0).UTC(),
+	}
+	id, err := CreateImage(config, []LayerWriter{layerContent(string(layer))}, "app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err := Save([]string{"app:v1"}, &saved); err != nil {
+		t.Fatalf("save error %v", err)
+	}
+
+	// 去掉 manifest.json 就是一个纯粹的 OCI image-layout
+	var ociOnly []archiveEntry
+	tr := tar.NewReader(bytes.NewReader(saved.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, _ := io.ReadAll(tr)
+		if hdr.Typeflag == tar.TypeReg && hdr.Name != dockerManifests {
+			ociOnly = append(ociOnly, archiveEntry{name: hdr.Name, content: string(content)})
+		}
+	}
+
+	for _, input := range []*bytes.Buffer{&saved, buildArchive(t, ociOnly)} {
+		RootUrl = t.TempDir()
+		loaded, err := Load(input)
+		if err != nil {
+			t.Fatalf("load error %v", err)
+		}
+		if len(loaded) != 1 || !reflect.DeepEqual(loaded[0].Refs, []string{"app:v1"}) {
+			t.Fatalf("unexpected loaded images %+v", loaded)
+		}
+		got, err := Get(loaded[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded[0].ID != id || !reflect.DeepEqual(got, config) {
+			t.Fatalf("config not preserved:\nexpect %s %+v\ngot    %s %+v", id, config, loaded[0].ID, got)
+		}
+	}
+}
+
+// 旧版本 docker save 的格式: <id>/layer.tar, 重复的镜像层是符号链接, 镜像层可以是 gzip 压缩的
+func TestLoadDockerArchive(t *testing.T) {
+	RootUrl = t.TempDir()
+	layer := layerTar(t, map[string]string{"bin/sh": "sh"})
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(layer)
+	zw.Close()
+
+	diffID := digestOf(layer)
+	configData, _ := json.Marshal(ociImageConfig{
+		Architecture: "amd64", OS: "linux",
+		Config: ociRuntimeConfig{Cmd: []string{"sh"}},
+		RootFS: ociRootFS{Type: "layers", DiffIDs: []string{diffID, diffID}},
+	})
+	configName := strings.TrimPrefix(digestOf(configData), DigestPrefix) + ".json"
+	manifest, _ := json.Marshal([]dockerManifest{{
+		Config:   configName,
+		RepoTags: []string{"docker.io/library/busybox:latest"},
+		Layers:   []string{"aaa/layer.tar", "bbb/layer.tar"},
+	}})
+	entries := []archiveEntry{
+		{name: configName, content: string(configData)},
+		{name: "aaa/layer.tar", content: gz.String()},
+		{name: "bbb/layer.tar", link: "../aaa/layer.tar"},
+		{name: "manifest.json", content: string(manifest)},
+	}
+	loaded, err := Load(buildArchive(t, entries))
+	if err != nil {
+		t.Fatalf("load error %v", err)
+	}
+	if len(loaded) != 1 || !reflect.DeepEqual(loaded[0].Refs, []string{"busybox:latest"}) {
+		t.Fatalf("unexpected loaded images %+v", loaded)
+	}
+	if config, err := Get(loaded[0].ID); err != nil || !reflect.DeepEqual(config.Layers, []string{diffID, diffID}) {
+		t.Fatalf("unexpected config %+v %v", config, err)
+	}
+
+	// 镜像层内容被篡改
+	entries[1].content = string(layerTar(t, map[string]string{"bin/sh": "evil"}))
+	if _, err := Load(buildArchive(t, entries)); err == nil || !strings.Contains(err.Error(), "diff id mismatch") {
+		t.Fatalf("expect diff id mismatch, got %v", err)
+	}
+	// 归档中的路径跳出临时目录
+	if _, err := Load(buildArchive(t, []archiveEntry{{name: "../escape", content: "x"}})); err == nil {
+		t.Fatalf("expect path traversal to be rejected")
+	}
+}
@@ -0,0 +1,131 @@
+package image
+
+import (
+	"runtime"
+	"time"
+)
+
+// docker save 和 OCI image-layout 中用到的格式, 只保留 mydocker 用到的字段
+const (
+	ociLayoutFile   = "oci-layout"
+	ociIndexFile    = "index.json"
+	ociBlobsDir     = "blobs"
+	dockerManifests = "manifest.json"
+
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeDockerList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// index.json 中记录镜像名的 annotation, 前者一般只有 tag, containerd 和 docker 用后者记录完整的镜像名
+	annotationRefName   = "org.opencontainers.image.ref.name"
+	annotationImageName = "io.containerd.image.name"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// index.json, 也可能作为多平台镜像的 blob 出现
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// docker save 的 manifest.json 中的一项, 路径相对于归档的根目录
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// OCI 和 docker 共用的镜像配置格式
+type ociImageConfig struct {
+	Created      *time.Time       `json:"created,omitempty"`
+	Author       string           `json:"author,omitempty"`
+	Comment      string           `json:"comment,omitempty"`
+	Architecture string           `json:"architecture"`
+	OS           string           `json:"os"`
+	Config       ociRuntimeConfig `json:"config"`
+	RootFS       ociRootFS        `json:"rootfs"`
+}
+
+type ociRuntimeConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// diff_ids 是各层未压缩的 tar 包的 sha256, 从最底层到最上层排列
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+func toOCIConfig(config *Config) *ociImageConfig {
+	oci := &ociImageConfig{
+		Author:       config.Author,
+		Comment:      config.Comment,
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config: ociRuntimeConfig{
+			User:         config.User,
+			ExposedPorts: config.ExposedPorts,
+			Env:          config.Env,
+			Entrypoint:   config.Entrypoint,
+			Cmd:          config.Cmd,
+			WorkingDir:   config.WorkingDir,
+			StopSignal:   config.StopSignal,
+		},
+		RootFS: ociRootFS{Type: "layers", DiffIDs: config.Layers},
+	}
+	if !config.Created.IsZero() {
+		created := config.Created
+		oci.Created = &created
+	}
+	if oci.RootFS.DiffIDs == nil {
+		oci.RootFS.DiffIDs = []string{}
+	}
+	return oci
+}
+
+// 镜像层由调用者在写入后填到 Layers 中
+func fromOCIConfig(oci *ociImageConfig) *Config {
+	config := &Config{
+		Env:          oci.Config.Env,
+		Entrypoint:   oci.Config.Entrypoint,
+		Cmd:          oci.Config.Cmd,
+		WorkingDir:   oci.Config.WorkingDir,
+		User:         oci.Config.User,
+		ExposedPorts: oci.Config.ExposedPorts,
+		StopSignal:   oci.Config.StopSignal,
+		Author:       oci.Author,
+		Comment:      oci.Comment,
+	}
+	if oci.Created != nil {
+		config.Created = *oci.Created
+	}
+	return config
+}
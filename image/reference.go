@@ -0,0 +1,45 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// 没有指定 tag 时使用的 tag
+const DefaultTag = "latest"
+
+var (
+	// 可选的仓库地址, 例如 localhost:5000/, 后面是 / 分隔的小写名字
+	nameRegexp = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$`)
+	tagRegexp  = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	hexRegexp  = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// 解析 name[:tag], 没有 tag 时为 latest
+// 最后一个 / 之后的冒号才是 tag 的分隔符, 之前的是仓库地址的端口
+func ParseReference(ref string) (string, string, error) {
+	name, tag := ref, DefaultTag
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	if !nameRegexp.MatchString(name) {
+		return "", "", fmt.Errorf("invalid reference format: %s", ref)
+	}
+	if hexRegexp.MatchString(name) {
+		return "", "", fmt.Errorf("invalid repository name (%s), cannot specify 64-byte hexadecimal strings", name)
+	}
+	if !tagRegexp.MatchString(tag) {
+		return "", "", fmt.Errorf("invalid tag format: %s", ref)
+	}
+	return name, tag, nil
+}
+
+// 把 ref 转换为 name:tag 的完整形式, 作为仓库索引的 key
+func NormalizeReference(ref string) (string, error) {
+	name, tag, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	return name + ":" + tag, nil
+}
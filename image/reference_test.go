@@ -0,0 +1,27 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	valid := map[string][2]string{
+		"busybox":                     {"busybox", "latest"},
+		"busybox:1.36":                {"busybox", "1.36"},
+		"library/nginx:stable-alpine": {"library/nginx", "stable-alpine"},
+		"localhost:5000/app":          {"localhost:5000/app", "latest"},
+		"localhost:5000/app:v1":       {"localhost:5000/app", "v1"},
+	}
+	for ref, expect := range valid {
+		name, tag, err := ParseReference(ref)
+		if err != nil || name != expect[0] || tag != expect[1] {
+			t.Fatalf("parse %s: got %s %s %v", ref, name, tag, err)
+		}
+	}
+	for _, ref := range []string{"", "Busybox", "busybox:", "busybox:-bad", "a//b", strings.Repeat("a", 64)} {
+		if _, _, err := ParseReference(ref); err == nil {
+			t.Fatalf("expect error for %q", ref)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+)
+
+// 把镜像导出为 OCI image-layout, 同时写入 docker save 格式的 manifest.json
+// 和新版本 docker save 的输出一样, docker load 和 OCI 工具都可以导入
+// 按 tag 导出时记录这个 tag, 按 ID 导出时不记录 tag
+func Save(refs []string, w io.Writer) error {
+	s := &saver{tw: tar.NewWriter(w), written: map[string]bool{}}
+	for _, dir := range []string{ociBlobsDir + "/", path.Join(ociBlobsDir, "sha256") + "/"} {
+		if err := s.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0755}); err != nil {
+			return err
+		}
+	}
+
+	index := ociIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex, Manifests: []ociDescriptor{}}
+	manifests := []dockerManifest{}
+	for _, ref := range refs {
+		id, err := Resolve(ref)
+		if err != nil {
+			return err
+		}
+		config, err := Get(id)
+		if err != nil {
+			return err
+		}
+		var repoTags []string
+		if normalized, err := NormalizeReference(ref); err == nil {
+			tags, err := References(id)
+			if err != nil {
+				return err
+			}
+			for _, tag := range tags {
+				if tag == normalized {
+					repoTags = append(repoTags, tag)
+				}
+			}
+		}
+
+		configDesc, err := s.writeJSONBlob(mediaTypeOCIConfig, toOCIConfig(config))
+		if err != nil {
+			return err
+		}
+		manifest := ociManifest{SchemaVersion: 2, MediaType: mediaTypeOCIManifest, Config: configDesc, Layers: []ociDescriptor{}}
+		docker := dockerManifest{Config: blobName(configDesc.Digest), RepoTags: repoTags, Layers: []string{}}
+		for _, layer := range config.Layers {
+			desc, err := s.writeLayer(layer)
+			if err != nil {
+				return err
+			}
+			manifest.Layers = append(manifest.Layers, desc)
+			docker.Layers = append(docker.Layers, blobName(desc.Digest))
+		}
+		manifestDesc, err := s.writeJSONBlob(mediaTypeOCIManifest, manifest)
+		if err != nil {
+			return err
+		}
+
+		if len(repoTags) == 0 {
+			index.Manifests = append(index.Manifests, manifestDesc)
+		}
+		for _, tag := range repoTags {
+			desc := manifestDesc
+			desc.Annotations = map[string]string{
+				annotationImageName: tag,
+				annotationRefName:   tagOf(tag),
+			}
+			index.Manifests = append(index.Manifests, desc)
+		}
+		manifests = append(manifests, docker)
+	}
+
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{ociLayoutFile, map[string]string{"imageLayoutVersion": "1.0.0"}},
+		{ociIndexFile, index},
+		{dockerManifests, manifests},
+	}
+	for _, f := range files {
+		data, err := json.Marshal(f.v)
+		if err != nil {
+			return err
+		}
+		if err := s.writeFile(f.name, data); err != nil {
+			return err
+		}
+	}
+	return s.tw.Close()
+}
+
+// name:tag 中的 tag
+func tagOf(ref string) string {
+	_, tag, _ := ParseReference(ref)
+	return tag
+}
+
+type saver struct {
+	tw *tar.Writer
+	// 多个镜像共用的 blob 只写一次
+	written map[string]bool
+}
+
+func blobName(digest string) string {
+	return path.Join(ociBlobsDir, "sha256", digestHex(digest))
+}
+
+func (s *saver) writeFile(name string, data []byte) error {
+	if err := s.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+		return err
+	}
+	_, err := s.tw.Write(data)
+	return err
+}
+
+func (s *saver) writeJSONBlob(mediaType string, v interface{}) (ociDescriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	sum := sha256.Sum256(data)
+	desc := ociDescriptor{MediaType: mediaType, Digest: DigestPrefix + hex.EncodeToString(sum[:]), Size: int64(len(data))}
+	if !s.written[desc.Digest] {
+		s.written[desc.Digest] = true
+		if err := s.writeFile(blobName(desc.Digest), data); err != nil {
+			return ociDescriptor{}, err
+		}
+	}
+	return desc, nil
+}
+
+// 镜像存储中的镜像层是未压缩的 tar 包, 摘要就是 diff_id
+func (s *saver) writeLayer(layer string) (ociDescriptor, error) {
+	f, err := os.Open(LayerPath(layer))
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	desc := ociDescriptor{MediaType: mediaTypeOCILayer, Digest: layer, Size: info.Size()}
+	if s.written[layer] {
+		return desc, nil
+	}
+	s.written[layer] = true
+	if err := s.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: blobName(layer), Mode: 0644, Size: info.Size()}); err != nil {
+		return ociDescriptor{}, err
+	}
+	_, err = io.Copy(s.tw, f)
+	return desc, err
+}
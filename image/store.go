@@ -0,0 +1,454 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mydocker/store"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 镜像存储的目录结构, 都在 RootUrl 下
+// layers/<sha256>.tar       镜像层 tar 包, 以内容的 sha256 命名
+// imagedb/<sha256>.json     镜像配置, 以配置内容的 sha256 作为镜像 ID
+// repositories.json         name:tag 到镜像 ID 的索引
+const (
+	LayersDir        = "layers"
+	ImageDBDir       = "imagedb"
+	repositoriesFile = "repositories.json"
+	storeLockFile    = "images.lock"
+
+	DigestPrefix = "sha256:"
+	// 镜像 ID 的短格式长度, 和容器一致
+	shortIDLength = 12
+)
+
+// 写入镜像, 修改 tag 和删除镜像层的操作都需要持有这个锁
+// 保证删除镜像时不会删掉正在写入的镜像引用的镜像层
+func lock() (*store.FileLock, error) {
+	if err := os.MkdirAll(RootUrl, 0755); err != nil {
+		return nil, err
+	}
+	return store.Lock(filepath.Join(RootUrl, storeLockFile))
+}
+
+func digestHex(digest string) string {
+	return strings.TrimPrefix(digest, DigestPrefix)
+}
+
+// 去掉 sha256: 前缀后的前 12 位
+func ShortID(id string) string {
+	h := digestHex(id)
+	if len(h) > shortIDLength {
+		return h[:shortIDLength]
+	}
+	return h
+}
+
+func configPath(id string) string {
+	return filepath.Join(RootUrl, ImageDBDir, digestHex(id)+".json")
+}
+
+// 镜像层 tar 包的路径
+func LayerPath(digest string) string {
+	return filepath.Join(RootUrl, LayersDir, digestHex(digest)+".tar")
+}
+
+// name:tag 到镜像 ID 的索引
+type repositories map[string]string
+
+func loadRepositories() (repositories, error) {
+	repos := repositories{}
+	err := store.ReadJSON(filepath.Join(RootUrl, repositoriesFile), &repos)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return repos, nil
+}
+
+func (r repositories) save() error {
+	return store.WriteJSON(filepath.Join(RootUrl, repositoriesFile), r)
+}
+
+// 读取镜像 ID 对应的配置
+func Get(id string) (*Config, error) {
+	config := &Config{}
+	if err := store.ReadJSON(configPath(id), config); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No such image: %s", id)
+		}
+		return nil, fmt.Errorf("read image config %s error %v", id, err)
+	}
+	return config, nil
+}
+
+// 按 name:tag, 完整 ID, 唯一的 ID 前缀的顺序查找镜像, 返回镜像 ID
+// 找不到时尝试导入旧版本的 /root/<name>.tar 镜像
+func Resolve(ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("image name or id is empty")
+	}
+	if normalized, err := NormalizeReference(ref); err == nil {
+		repos, err := loadRepositories()
+		if err != nil {
+			return "", err
+		}
+		if id, ok := repos[normalized]; ok {
+			return id, nil
+		}
+	}
+
+	prefix := digestHex(ref)
+	if hexRegexp.MatchString(prefix) {
+		if _, err := os.Stat(configPath(prefix)); err == nil {
+			return DigestPrefix + prefix, nil
+		}
+	} else if len(prefix) > 0 && strings.Trim(prefix, "0123456789abcdef") == "" {
+		ids, err := imageIDs()
+		if err != nil {
+			return "", err
+		}
+		var matches []string
+		for _, id := range ids {
+			if strings.HasPrefix(digestHex(id), prefix) {
+				matches = append(matches, id)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+		if len(matches) > 1 {
+			return "", fmt.Errorf("multiple images found with provided prefix %s", ref)
+		}
+	}
+
+	if name, tag, err := ParseReference(ref); err == nil && tag == DefaultTag && legacyExists(name) {
+		return importLegacy(name)
+	}
+	return "", fmt.Errorf("No such image: %s", ref)
+}
+
+// 查找镜像并读取配置
+func LoadConfig(ref string) (string, *Config, error) {
+	id, err := Resolve(ref)
+	if err != nil {
+		return "", nil, err
+	}
+	config, err := Get(id)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, config, nil
+}
+
+// 所有镜像的 ID
+func imageIDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(RootUrl, ImageDBDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		if hexRegexp.MatchString(name) {
+			ids = append(ids, DigestPrefix+name)
+		}
+	}
+	return ids, nil
+}
+
+// 镜像层的写入函数, 把 tar 包内容写入 w
+type LayerWriter func(w io.Writer) error
+
+// 写入新镜像: 依次保存 layers 中的镜像层并追加到 config.Layers 末尾, 保存配置, 打上 refs 中的 tag
+// 返回镜像 ID, 内容相同的镜像层和配置只保存一份
+func CreateImage(config *Config, layers []LayerWriter, refs ...string) (string, error) {
+	var normalized []string
+	for _, ref := range refs {
+		n, err := NormalizeReference(ref)
+		if err != nil {
+			return "", err
+		}
+		normalized = append(normalized, n)
+	}
+	l, err := lock()
+	if err != nil {
+		return "", err
+	}
+	defer l.Unlock()
+	return createImage(config, layers, normalized)
+}
+
+// 调用者需要持有锁, refs 已经是 name:tag 的形式
+func createImage(config *Config, layers []LayerWriter, refs []string) (string, error) {
+	for _, layer := range layers {
+		digest, err := addLayer(layer)
+		if err != nil {
+			return "", err
+		}
+		config.Layers = append(config.Layers, digest)
+	}
+	for _, digest := range config.Layers {
+		if _, err := os.Stat(LayerPath(digest)); err != nil {
+			return "", fmt.Errorf("layer %s not found", digest)
+		}
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	id := DigestPrefix + hex.EncodeToString(sum[:])
+	if err := store.WriteFileAtomic(configPath(id), data, 0644); err != nil {
+		return "", err
+	}
+	if len(refs) == 0 {
+		return id, nil
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range refs {
+		repos[ref] = id
+	}
+	return id, repos.save()
+}
+
+// 把镜像层写到临时文件, 同时计算 sha256, 写完后以 sha256 命名
+func addLayer(write LayerWriter) (string, error) {
+	layersDir := filepath.Join(RootUrl, LayersDir)
+	if err := os.MkdirAll(layersDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(layersDir, ".layer-*.tar")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	digest := sha256.New()
+	if err := write(io.MultiWriter(tmp, digest)); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	layer := DigestPrefix + hex.EncodeToString(digest.Sum(nil))
+	if err := os.Rename(tmp.Name(), LayerPath(layer)); err != nil {
+		return "", err
+	}
+	return layer, nil
+}
+
+// 给镜像 id 打上 tag ref, ref 原来指向的镜像失去这个 tag
+func Tag(id, ref string) error {
+	normalized, err := NormalizeReference(ref)
+	if err != nil {
+		return err
+	}
+	l, err := lock()
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	if _, err := os.Stat(configPath(id)); err != nil {
+		return fmt.Errorf("No such image: %s", id)
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repos[normalized] = id
+	return repos.save()
+}
+
+// 删除 tag ref, 返回删除的 name:tag
+func Untag(ref string) (string, error) {
+	normalized, err := NormalizeReference(ref)
+	if err != nil {
+		return "", err
+	}
+	l, err := lock()
+	if err != nil {
+		return "", err
+	}
+	defer l.Unlock()
+	repos, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := repos[normalized]; !ok {
+		return "", fmt.Errorf("No such image: %s", ref)
+	}
+	delete(repos, normalized)
+	return normalized, repos.save()
+}
+
+// 指向镜像 id 的所有 name:tag
+func References(id string) ([]string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for ref, target := range repos {
+		if target == id {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
+
+// mydocker images 列出的一个镜像
+type Summary struct {
+	ID     string
+	Refs   []string // 没有 tag 的镜像为空
+	Config *Config
+	Size   int64 // 所有镜像层 tar 包的大小
+}
+
+func List() ([]Summary, error) {
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, err
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string][]string{}
+	for ref, id := range repos {
+		refs[id] = append(refs[id], ref)
+	}
+	var images []Summary
+	for _, id := range ids {
+		config, err := Get(id)
+		if err != nil {
+			return nil, err
+		}
+		summary := Summary{ID: id, Refs: refs[id], Config: config}
+		sort.Strings(summary.Refs)
+		for _, layer := range config.Layers {
+			if info, err := os.Stat(LayerPath(layer)); err == nil {
+				summary.Size += info.Size()
+			}
+		}
+		images = append(images, summary)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Config.Created.After(images[j].Config.Created)
+	})
+	return images, nil
+}
+
+// 删除镜像 id 和指向它的 tag, 再删除不再被任何镜像引用的镜像层, 返回删除的镜像层
+// 调用者负责检查没有容器在使用这个镜像
+func Delete(id string) ([]string, error) {
+	return DeleteIfUnused(id, nil)
+}
+
+// 和 Delete 一样, 但是在持有镜像存储的锁时先调用 inUse 检查镜像是否还在使用, inUse 返回错误时不删除
+// 创建容器时通过 Use 持有同一个锁记录镜像 ID, 检查和删除之间不会有新的容器开始使用这个镜像
+func DeleteIfUnused(id string, inUse func(id string) error) ([]string, error) {
+	l, err := lock()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Unlock()
+	if inUse != nil {
+		if err := inUse(id); err != nil {
+			return nil, err
+		}
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	changed := false
+	for ref, target := range repos {
+		if target == id {
+			delete(repos, ref)
+			changed = true
+		}
+	}
+	if changed {
+		if err := repos.save(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(configPath(id)); err != nil {
+		return nil, err
+	}
+	return removeUnusedLayers()
+}
+
+// 持有镜像存储的锁, 确认镜像 id 还存在后调用 fn, 用于创建容器时记录镜像 ID
+// 和 DeleteIfUnused 互斥, fn 中不能再调用会获取这个锁的函数
+func Use(id string, fn func() error) error {
+	l, err := lock()
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	if _, err := os.Stat(configPath(id)); err != nil {
+		return fmt.Errorf("No such image: %s", id)
+	}
+	return fn()
+}
+
+// 统计所有镜像对每个镜像层的引用, 删除引用数为 0 的镜像层和它解压后的目录
+func removeUnusedLayers() ([]string, error) {
+	ids, err := imageIDs()
+	if err != nil {
+		return nil, err
+	}
+	refCount := map[string]int{}
+	for _, id := range ids {
+		config, err := Get(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range config.Layers {
+			refCount[digestHex(layer)]++
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(RootUrl, LayersDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var removed []string
+	for _, entry := range entries {
+		h := strings.TrimSuffix(entry.Name(), ".tar")
+		if !hexRegexp.MatchString(h) || !strings.HasSuffix(entry.Name(), ".tar") || refCount[h] > 0 {
+			continue
+		}
+		// 各个存储驱动解压出来的目录: <sha256> 和 <sha256>.<driver>
+		dirs, _ := filepath.Glob(filepath.Join(RootUrl, LayersDir, h+".*"))
+		dirs = append(dirs, filepath.Join(RootUrl, LayersDir, h))
+		for _, dir := range dirs {
+			if err := os.RemoveAll(dir); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, DigestPrefix+h)
+	}
+	return removed, nil
+}
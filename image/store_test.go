@@ -0,0 +1,101 @@
+package image
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func layerContent(content string) LayerWriter {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}
+}
+
+func TestImageStore(t *testing.T) {
+	RootUrl = t.TempDir()
+
+	baseID, err := CreateImage(&Config{Created: time.Unix(1, 0)}, []LayerWriter{layerContent("base")}, "base")
+	if err != nil {
+		t.Fatalf("create base image error %v", err)
+	}
+	base, err := Get(baseID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	childID, err := CreateImage(&Config{Created: time.Unix(2, 0), Parent: baseID, Layers: base.Layers},
+		[]LayerWriter{layerContent("child")}, "child:v1")
+	if err != nil {
+		t.Fatalf("create child image error %v", err)
+	}
+
+	for ref, expect := range map[string]string{"base": baseID, "base:latest": baseID, "child:v1": childID, ShortID(childID): childID, childID: childID} {
+		if id, err := Resolve(ref); err != nil || id != expect {
+			t.Fatalf("resolve %s: got %s %v", ref, id, err)
+		}
+	}
+	if _, err := Resolve("child"); err == nil {
+		t.Fatalf("child:latest should not exist")
+	}
+
+	if err := Tag(childID, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if refs, _ := References(childID); !reflect.DeepEqual(refs, []string{"child:v1", "other:latest"}) {
+		t.Fatalf("unexpected refs %v", refs)
+	}
+
+	// 基础镜像的镜像层还被 child 使用, 不能删除
+	removed, err := Delete(baseID)
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("delete base: removed %v, error %v", removed, err)
+	}
+	if _, err := os.Stat(LayerPath(base.Layers[0])); err != nil {
+		t.Fatalf("shared layer removed: %v", err)
+	}
+	// 还在使用的镜像不会被删除
+	if _, err := DeleteIfUnused(childID, func(string) error { return os.ErrExist }); err != os.ErrExist {
+		t.Fatalf("delete image in use: expect error, got %v", err)
+	}
+	if _, err := Get(childID); err != nil {
+		t.Fatalf("image in use was deleted: %v", err)
+	}
+	if err := Use(childID, func() error { return nil }); err != nil {
+		t.Fatalf("use image error %v", err)
+	}
+	removed, err = Delete(childID)
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("delete child: removed %v, error %v", removed, err)
+	}
+	if refs, _ := References(childID); len(refs) != 0 {
+		t.Fatalf("tags of deleted image remain: %v", refs)
+	}
+	if err := Use(childID, func() error { return nil }); err == nil {
+		t.Fatalf("use deleted image should fail")
+	}
+}
+
+func TestImportLegacyImage(t *testing.T) {
+	RootUrl = t.TempDir()
+	if err := os.WriteFile(filepath.Join(RootUrl, "busybox.tar"), []byte("rootfs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	id, err := Resolve("busybox")
+	if err != nil {
+		t.Fatalf("resolve legacy image error %v", err)
+	}
+	config, err := Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Layers) != 1 || !reflect.DeepEqual(config.Env, []string{DefaultPathEnv}) {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if again, err := Resolve("busybox:latest"); err != nil || again != id {
+		t.Fatalf("legacy image imported twice: %s %v", again, err)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mydocker/container"
+	"mydocker/image"
+	"mydocker/term"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// 列出所有镜像, 一个 tag 一行, 没有 tag 的镜像显示为 <none>
+func listImages(quiet bool) error {
+	images, err := image.List()
+	if err != nil {
+		return err
+	}
+	if quiet {
+		for _, img := range images {
+			fmt.Fprintln(os.Stdout, image.ShortID(img.ID))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, img := range images {
+		refs := img.Refs
+		if len(refs) == 0 {
+			refs = []string{"<none>:<none>"}
+		}
+		created := ""
+		if !img.Config.Created.IsZero() {
+			created = img.Config.Created.Local().Format("2006-01-02 15:04:05")
+		}
+		for _, ref := range refs {
+			i := strings.LastIndex(ref, ":")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ref[:i], ref[i+1:], image.ShortID(img.ID), created, humanSize(img.Size))
+		}
+	}
+	return w.Flush()
+}
+
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", size, units[i])
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
+
+// 给 source 对应的镜像打上 target 这个 tag
+func tagImage(source, target string) error {
+	id, err := image.Resolve(source)
+	if err != nil {
+		return err
+	}
+	return image.Tag(id, target)
+}
+
+// 依次删除镜像, 某个镜像删除失败时继续删除后面的
+func removeImages(refs []string, force bool) error {
+	failed := 0
+	for _, ref := range refs {
+		if err := removeImage(ref, force); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d image(s)", failed)
+	}
+	return nil
+}
+
+// 按 tag 删除时只删除这个 tag, 镜像没有其他 tag 时再删除镜像本身
+// 按 ID 删除时镜像有多个 tag 需要 force, 有容器在使用的镜像不会被删除, force 时只删除 tag
+func removeImage(ref string, force bool) error {
+	id, err := image.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	refs, err := image.References(id)
+	if err != nil {
+		return err
+	}
+	byTag := false
+	if normalized, err := image.NormalizeReference(ref); err == nil {
+		for _, r := range refs {
+			if r == normalized {
+				byTag = true
+				break
+			}
+		}
+		if byTag {
+			// 镜像还有其他 tag 时只删除这一个
+			if len(refs) > 1 {
+				return untagImages([]string{normalized})
+			}
+			refs = []string{normalized}
+		}
+	}
+	if !byTag && len(refs) > 1 && !force {
+		return fmt.Errorf("conflict: unable to delete %s (must be forced) - image is referenced in multiple repositories", image.ShortID(id))
+	}
+
+	if users := imageUsers(id); len(users) > 0 && force {
+		// 强制删除时只删除 tag, 镜像和镜像层留给容器使用
+		if len(refs) > 0 {
+			return untagImages(refs)
+		}
+		return fmt.Errorf("conflict: unable to delete %s (cannot be forced) - image is being used by container %s",
+			image.ShortID(id), container.ShortID(users[0].Id))
+	}
+
+	// 在镜像存储的锁内再检查一次, 和 mydocker run 记录镜像 ID 互斥
+	layers, err := image.DeleteIfUnused(id, func(id string) error {
+		if users := imageUsers(id); len(users) > 0 {
+			return fmt.Errorf("conflict: unable to remove image %s (must force) - container %s is using its referenced image %s",
+				ref, container.ShortID(users[0].Id), image.ShortID(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// 指向这个镜像的 tag 由 DeleteIfUnused 一起删除
+	for _, r := range refs {
+		fmt.Fprintf(os.Stdout, "Untagged: %s\n", r)
+	}
+	fmt.Fprintf(os.Stdout, "Deleted: %s\n", id)
+	for _, layer := range layers {
+		fmt.Fprintf(os.Stdout, "Deleted: %s\n", layer)
+	}
+	return nil
+}
+
+func untagImages(refs []string) error {
+	for _, ref := range refs {
+		if _, err := image.Untag(ref); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Untagged: %s\n", ref)
+	}
+	return nil
+}
+
+// 使用镜像 id 的容器, 容器的可写层依赖镜像的各层, 镜像不能被删除
+func imageUsers(id string) []*container.ContainerInfo {
+	refs, _ := image.References(id)
+	var users []*container.ContainerInfo
+	for _, info := range getAllContainerInfos() {
+		if info.ImageID == id {
+			users = append(users, info)
+			continue
+		}
+		// 旧版本的容器只记录了镜像名, 按名字比较, 不能用 Resolve, 否则会触发旧镜像的导入
+		if info.ImageID == "" {
+			normalized, err := image.NormalizeReference(info.Image)
+			if err != nil {
+				continue
+			}
+			for _, r := range refs {
+				if r == normalized {
+					users = append(users, info)
+					break
+				}
+			}
+		}
+	}
+	return users
+}
+
+// 导入 docker save 或 OCI image-layout 格式的归档, input 为空时从标准输入读取
+func loadImages(input string) error {
+	var r io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	} else if term.IsTerminal(os.Stdin.Fd()) {
+		return fmt.Errorf("requested load from stdin, but stdin is empty")
+	}
+	loaded, err := image.Load(r)
+	for _, img := range loaded {
+		if len(img.Refs) == 0 {
+			fmt.Fprintf(os.Stdout, "Loaded image ID: %s\n", img.ID)
+		}
+		for _, ref := range img.Refs {
+			fmt.Fprintf(os.Stdout, "Loaded image: %s\n", ref)
+		}
+	}
+	return err
+}
+
+// 导出镜像, output 为空时写到标准输出, 写文件时先写临时文件, 完成后再改名
+func saveImages(refs []string, output string) error {
+	if output == "" {
+		if term.IsTerminal(os.Stdout.Fd()) {
+			return fmt.Errorf("cowardly refusing to save to a terminal. Use the -o flag or redirect")
+		}
+		return image.Save(refs, os.Stdout)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := image.Save(refs, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), output)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"mydocker/graphdriver"
+	"os"
+	"strings"
+)
+
+// 输出存储驱动等全局信息, storageDriver 为全局参数 --storage-driver 的值
+func showInfo(storageDriver string) error {
+	driver, err := graphdriver.New(storageDriver)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Containers: %d\n", len(getAllContainerInfos()))
+	fmt.Fprintf(os.Stdout, "Storage Driver: %s\n", driver)
+	for _, status := range driver.Status() {
+		fmt.Fprintf(os.Stdout, " %s: %s\n", status[0], status[1])
+	}
+	fmt.Fprintf(os.Stdout, "Available Storage Drivers: %s\n", strings.Join(graphdriver.Drivers(), " "))
+	return nil
+}
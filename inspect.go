@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mydocker/network"
+	"net"
+	"os"
+	"text/template"
+)
+
+// mydocker inspect 输出的网络信息
+type networkInspect struct {
+	Name       string
+	Driver     string
+	Subnet     string
+	Gateway    string
+	Containers map[string]networkContainer
+}
+
+type networkContainer struct {
+	Name        string
+	EndpointID  string
+	IPv4Address string
+	MacAddress  string
+}
+
+// 打印容器或网络的详细信息, format 为空时输出 JSON, 否则按 Go template 输出
+func inspect(names []string, format string) error {
+	var tmpl *template.Template
+	if format != "" {
+		var err error
+		tmpl, err = template.New("inspect").Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(format)
+		if err != nil {
+			return fmt.Errorf("template parsing error: %v", err)
+		}
+	}
+
+	var objects []interface{}
+	for _, name := range names {
+		object, err := getInspectObject(name)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, object)
+	}
+
+	if tmpl == nil {
+		content, err := json.MarshalIndent(objects, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(content))
+		return nil
+	}
+	for _, object := range objects {
+		if err := tmpl.Execute(os.Stdout, object); err != nil {
+			return fmt.Errorf("template execute error: %v", err)
+		}
+		fmt.Fprintln(os.Stdout)
+	}
+	return nil
+}
+
+// 先按容器查找, 找不到再按网络查找
+func getInspectObject(name string) (interface{}, error) {
+	info, err := resolveContainer(name)
+	if err == nil {
+		// 被强制杀掉的 exec 会话来不及删除自己的记录, 不显示已经退出的
+		sessions := info.ExecSessions[:0]
+		for _, s := range info.ExecSessions {
+			if s.Pid == 0 || processExists(s.Pid) {
+				sessions = append(sessions, s)
+			}
+		}
+		info.ExecSessions = sessions
+		return info, nil
+	}
+
+	network.Init()
+	nw, ok := network.GetNetwork(name)
+	if !ok {
+		// 既不是网络也没有唯一匹配的容器时, 返回容器查找的错误, 例如前缀不唯一
+		return nil, err
+	}
+	result := &networkInspect{
+		Name:       nw.Name,
+		Driver:     nw.Driver,
+		Containers: map[string]networkContainer{},
+	}
+	if nw.IpRange != nil {
+		subnet := net.IPNet{IP: nw.IpRange.IP.Mask(nw.IpRange.Mask), Mask: nw.IpRange.Mask}
+		result.Subnet = subnet.String()
+		result.Gateway = nw.IpRange.IP.String()
+	}
+	for _, info := range getAllContainerInfos() {
+		if info.NetworkSettings.Network != nw.Name {
+			continue
+		}
+		result.Containers[info.Id] = networkContainer{
+			Name:        info.Name,
+			EndpointID:  info.NetworkSettings.EndpointID,
+			IPv4Address: fmt.Sprintf("%s/%d", info.NetworkSettings.IPAddress, info.NetworkSettings.IPPrefixLen),
+			MacAddress:  info.NetworkSettings.MacAddress,
+		}
+	}
+	return result, nil
+}
@@ -12,12 +12,41 @@ import (
 )
 
 func ListContainers() {
+	containers := getAllContainerInfos()
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	for _, item := range containers {
+		status := item.Status
+		// 运行中的容器附带显示健康状态, 例如 running (healthy)
+		if item.Health != nil && item.Status == container.RUNNING {
+			status = fmt.Sprintf("%s (%s)", item.Status, item.Health.Status)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			container.ShortID(item.Id),
+			item.Name,
+			item.Pid,
+			status,
+			item.Command,
+			item.CreatedTime)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+		return
+	}
+}
+
+func getAllContainerInfos() []*container.ContainerInfo {
 	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
 	dirURL = dirURL[:len(dirURL)-1]
 	files, err := ioutil.ReadDir(dirURL)
 	if err != nil {
+		// 还没有创建过容器
+		if os.IsNotExist(err) {
+			return nil
+		}
 		logrus.Errorf("Read dir %s error %v", dirURL, err)
-		return
+		return nil
 	}
 
 	var containers []*container.ContainerInfo
@@ -29,27 +58,17 @@ func ListContainers() {
 		}
 		containers = append(containers, tmpContainer)
 	}
+	return containers
+}
 
-	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
-	for _, item := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			item.Id,
-			item.Name,
-			item.Pid,
-			item.Status,
-			item.Command,
-			item.CreatedTime)
-	}
-	if err := w.Flush(); err != nil {
-		logrus.Errorf("Flush error %v", err)
-		return
-	}
+// 通过容器 ID, 容器名或唯一的 ID 前缀找到容器
+func resolveContainer(ref string) (*container.ContainerInfo, error) {
+	return container.MatchContainer(getAllContainerInfos(), ref)
 }
 
 func getContainerInfo(file os.FileInfo) (*container.ContainerInfo, error) {
-	containerName := file.Name()
-	configFileDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	containerId := file.Name()
+	configFileDir := fmt.Sprintf(container.DefaultInfoLocation, containerId)
 	configFileDir = configFileDir + container.ConfigName
 	content, err := os.ReadFile(configFileDir)
 	if err != nil {
@@ -3,26 +3,101 @@ package main
 import (
 	"fmt"
 	"mydocker/container"
+	"mydocker/logger"
 	"os"
-
-	"io"
+	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
 )
 
-func logContainer(containerName string) {
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	logFileLocation := dirURL + container.ContainerLogFile
-	file, err := os.Open(logFileLocation)
-	defer file.Close()
+type logsOptions struct {
+	config     logger.ReadConfig
+	timestamps bool
+}
+
+func parseLogsOptions(ctx *cli.Context) (*logsOptions, error) {
+	opts := &logsOptions{
+		config: logger.ReadConfig{
+			Tail:   -1,
+			Follow: ctx.Bool("follow"),
+		},
+		timestamps: ctx.Bool("timestamps"),
+	}
+	if tail := ctx.String("tail"); tail != "all" && tail != "" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid tail value %s", tail)
+		}
+		opts.config.Tail = n
+	}
+	now := time.Now()
+	var err error
+	if opts.config.Since, err = parseLogTime(ctx.String("since"), now); err != nil {
+		return nil, err
+	}
+	if opts.config.Until, err = parseLogTime(ctx.String("until"), now); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// 支持 RFC3339 时间, unix 时间戳, 以及相对当前时间的时长, 例如 10m
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if sec, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time value %s", value)
+}
+
+func logContainer(containerName string, opts *logsOptions) error {
+	containerInfo, err := resolveContainer(containerName)
 	if err != nil {
-		logrus.Errorf("Log container open file %s error %v", logFileLocation, err)
-		return
+		return fmt.Errorf("Log container %s error %v", containerName, err)
 	}
-	content, err := io.ReadAll(io.Reader(file))
+	driver := containerInfo.LogConfig.Type
+	if !logger.SupportsRead(driver) {
+		return fmt.Errorf("configured logging driver %s does not support reading", driver)
+	}
+	if driver != logger.JSONFileDriver && (opts.timestamps || !opts.config.Since.IsZero() || !opts.config.Until.IsZero()) {
+		logrus.Warnf("Container %s uses raw log format without timestamps, ignore since, until and timestamps", containerName)
+	}
+	config := opts.config
+	config.Stopped = func() bool {
+		info, err := getContainerInfoById(containerInfo.Id)
+		return err != nil || containerExited(info)
+	}
+
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
+	logFileLocation := dirURL + container.ContainerLogFile
+	// 按日志驱动的格式解析, stdout 和 stderr 的内容分别写回对应的输出
+	err = logger.ReadLogs(driver, logFileLocation, config, func(msg *logger.Message) error {
+		out := os.Stdout
+		if msg.Source == "stderr" {
+			out = os.Stderr
+		}
+		if opts.timestamps && !msg.Timestamp.IsZero() {
+			if _, err := fmt.Fprint(out, msg.Timestamp.Format(time.RFC3339Nano)+" "); err != nil {
+				return err
+			}
+		}
+		_, err := out.Write(msg.Line)
+		return err
+	})
 	if err != nil {
-		logrus.Errorf("Log container read file %s error %v", logFileLocation, err)
-		return
+		return fmt.Errorf("Log container read file %s error %v", logFileLocation, err)
 	}
-	fmt.Fprint(os.Stdout, string(content))
+	return nil
 }
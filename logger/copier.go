@@ -0,0 +1,64 @@
+package logger
+
+import (
+	"bytes"
+	"time"
+)
+
+// 单行日志的最大长度, 超过后会被拆成多条
+const maxLineSize = 16 * 1024
+
+// 把容器某个输出流的数据按行切分后交给 Logger
+// 不完整的行先缓存起来, Close 时写出
+type LineWriter struct {
+	logger Logger
+	source string
+	buf    []byte
+}
+
+func NewLineWriter(logger Logger, source string) *LineWriter {
+	return &LineWriter{
+		logger: logger,
+		source: source,
+	}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.log(w.buf[:i+1]); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	for len(w.buf) >= maxLineSize {
+		if err := w.log(w.buf[:maxLineSize]); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[maxLineSize:]
+	}
+	return len(p), nil
+}
+
+// 写出缓存中剩下的不完整的行
+func (w *LineWriter) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.log(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *LineWriter) log(line []byte) error {
+	msg := &Message{
+		Line:      append([]byte(nil), line...),
+		Source:    w.source,
+		Timestamp: time.Now(),
+	}
+	return w.logger.Log(msg)
+}
@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+)
+
+const (
+	FluentdDriver = "fluentd"
+
+	defaultFluentdAddress = "tcp://127.0.0.1:24224"
+)
+
+func init() {
+	register(FluentdDriver, newFluentdLogger, validateFluentdOpts, false)
+}
+
+// 通过 TCP 发送到 fluentd 的 in_forward, 使用 forward 协议的 JSON 形式
+// 每条日志为 [tag, time, record]
+type fluentdLogger struct {
+	conn *remoteConn
+	info Info
+}
+
+type fluentdRecord struct {
+	Log           string `json:"log"`
+	Source        string `json:"source"`
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+}
+
+func validateFluentdOpts(opts map[string]string) error {
+	if err := validateKeys(FluentdDriver, opts, "fluentd-address", "tag"); err != nil {
+		return err
+	}
+	if address := opts["fluentd-address"]; address != "" {
+		if _, _, err := parseFluentdAddress(address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 和 docker 一样支持省略 tcp://, 直接写 host:port
+func parseFluentdAddress(address string) (string, string, error) {
+	if address == "" {
+		address = defaultFluentdAddress
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		address = "tcp://" + address
+	}
+	return parseAddress(address, "tcp")
+}
+
+func newFluentdLogger(info Info) (Logger, error) {
+	network, addr, err := parseFluentdAddress(info.Config["fluentd-address"])
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialRemote(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &fluentdLogger{conn: conn, info: info}, nil
+}
+
+func (l *fluentdLogger) Log(msg *Message) error {
+	data, err := json.Marshal([]interface{}{
+		l.info.Tag(),
+		msg.Timestamp.Unix(),
+		&fluentdRecord{
+			Log:           string(bytes.TrimRight(msg.Line, "\n")),
+			Source:        msg.Source,
+			ContainerID:   l.info.ContainerID,
+			ContainerName: l.info.ContainerName,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return l.conn.Write(data)
+}
+
+func (l *fluentdLogger) Close() error {
+	return l.conn.Close()
+}
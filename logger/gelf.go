@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+const GelfDriver = "gelf"
+
+func init() {
+	register(GelfDriver, newGelfLogger, validateGelfOpts, false)
+}
+
+// 以 GELF 1.1 格式通过 TCP 发送, 每条消息以 \0 结尾
+type gelfLogger struct {
+	conn     *remoteConn
+	hostname string
+	info     Info
+}
+
+type gelfMessage struct {
+	Version       string  `json:"version"`
+	Host          string  `json:"host"`
+	ShortMessage  string  `json:"short_message"`
+	Timestamp     float64 `json:"timestamp"`
+	Level         int     `json:"level"`
+	ContainerID   string  `json:"_container_id"`
+	ContainerName string  `json:"_container_name"`
+	Tag           string  `json:"_tag"`
+	Stream        string  `json:"_stream"`
+}
+
+func validateGelfOpts(opts map[string]string) error {
+	if err := validateKeys(GelfDriver, opts, "gelf-address", "tag"); err != nil {
+		return err
+	}
+	if opts["gelf-address"] == "" {
+		return fmt.Errorf("log driver %s requires gelf-address, e.g. tcp://127.0.0.1:12201", GelfDriver)
+	}
+	_, _, err := parseAddress(opts["gelf-address"], "tcp")
+	return err
+}
+
+func newGelfLogger(info Info) (Logger, error) {
+	network, addr, err := parseAddress(info.Config["gelf-address"], "tcp")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialRemote(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &gelfLogger{conn: conn, hostname: hostname(), info: info}, nil
+}
+
+func (l *gelfLogger) Log(msg *Message) error {
+	level := severityInfo
+	if msg.Source == "stderr" {
+		level = severityErr
+	}
+	data, err := json.Marshal(&gelfMessage{
+		Version:       "1.1",
+		Host:          l.hostname,
+		ShortMessage:  string(bytes.TrimRight(msg.Line, "\n")),
+		Timestamp:     float64(msg.Timestamp.UnixNano()) / 1e9,
+		Level:         level,
+		ContainerID:   l.info.ContainerID,
+		ContainerName: l.info.ContainerName,
+		Tag:           l.info.Tag(),
+		Stream:        msg.Source,
+	})
+	if err != nil {
+		return err
+	}
+	return l.conn.Write(append(data, 0))
+}
+
+func (l *gelfLogger) Close() error {
+	return l.conn.Close()
+}
@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// json-file 格式中的一行, 和 docker 的格式保持一致
+// {"log":"hello\n","stream":"stdout","time":"2024-01-01T00:00:00.000000000Z"}
+type jsonLog struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func init() {
+	register(JSONFileDriver, newJSONFileLogger, validateFileOpts, true)
+}
+
+type jsonFileLogger struct {
+	file *rotatingFile
+}
+
+func newJSONFileLogger(info Info) (Logger, error) {
+	file, err := openLogFile(info)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonFileLogger{file: file}, nil
+}
+
+func (l *jsonFileLogger) Log(msg *Message) error {
+	line, err := json.Marshal(&jsonLog{
+		Log:    string(msg.Line),
+		Stream: msg.Source,
+		Time:   msg.Timestamp.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+func (l *jsonFileLogger) Close() error {
+	return l.file.Close()
+}
+
+// 解析 json-file 中的一行
+func decodeJSONLog(line []byte) (*Message, error) {
+	var entry jsonLog
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return nil, err
+	}
+	return &Message{
+		Line:      []byte(entry.Log),
+		Source:    entry.Stream,
+		Timestamp: entry.Time,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// 每行一个 JSON 对象, 记录输出流和时间戳
+	JSONFileDriver = "json-file"
+	// 原样写入容器的输出, 兼容旧版本的 container.log
+	RawDriver = "raw"
+
+	DefaultDriver = JSONFileDriver
+)
+
+// 容器输出的一行日志
+type Message struct {
+	Line      []byte
+	Source    string // stdout 或 stderr
+	Timestamp time.Time
+}
+
+type Logger interface {
+	Log(msg *Message) error
+	Close() error
+}
+
+// 创建日志驱动时需要的容器信息
+type Info struct {
+	ContainerID   string
+	ContainerName string
+	LogPath       string            // 本地日志文件的路径, 只有 json-file 和 raw 使用
+	Config        map[string]string // --log-opt 传入的参数
+}
+
+// 日志中标识容器的 tag, 默认为短 ID
+func (info Info) Tag() string {
+	if tag := info.Config["tag"]; tag != "" {
+		return tag
+	}
+	if len(info.ContainerID) > 12 {
+		return info.ContainerID[:12]
+	}
+	return info.ContainerID
+}
+
+type driver struct {
+	create   func(info Info) (Logger, error)
+	validate func(opts map[string]string) error
+	readable bool // 日志写在本地, mydocker logs 可以读回来
+}
+
+var drivers = map[string]driver{}
+
+// 注册日志驱动, 由各个驱动在 init 中调用
+func register(name string, create func(Info) (Logger, error), validate func(map[string]string) error, readable bool) {
+	if _, ok := drivers[name]; ok {
+		panic(fmt.Sprintf("log driver %s already registered", name))
+	}
+	drivers[name] = driver{create: create, validate: validate, readable: readable}
+}
+
+// driver 为空表示没有记录日志驱动的旧容器, 按 raw 处理
+func getDriver(name string) (driver, error) {
+	if name == "" {
+		name = RawDriver
+	}
+	d, ok := drivers[name]
+	if !ok {
+		return driver{}, fmt.Errorf("unknown log driver %s, available drivers: %v", name, Drivers())
+	}
+	return d, nil
+}
+
+// 所有已注册的日志驱动名
+func Drivers() []string {
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 创建 name 对应的日志驱动
+func New(name string, info Info) (Logger, error) {
+	d, err := getDriver(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.validate(info.Config); err != nil {
+		return nil, err
+	}
+	return d.create(info)
+}
+
+// 检查日志驱动和 --log-opt 参数, 在 mydocker run 时尽早报错
+func ValidateOpts(name string, opts map[string]string) error {
+	d, err := getDriver(name)
+	if err != nil {
+		return err
+	}
+	return d.validate(opts)
+}
+
+// 日志驱动是否支持 mydocker logs 读取
+func SupportsRead(name string) bool {
+	d, err := getDriver(name)
+	return err == nil && d.readable
+}
+
+// 检查 opts 中只包含 allowed 中的参数
+func validateKeys(name string, opts map[string]string, allowed ...string) error {
+	for key := range opts {
+		found := false
+		for _, a := range allowed {
+			if key == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown log opt %s for log driver %s", key, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container.log")
+	l, err := New(JSONFileDriver, Info{LogPath: path})
+	if err != nil {
+		t.Fatalf("new logger error %v", err)
+	}
+	stdout := NewLineWriter(l, "stdout")
+	stderr := NewLineWriter(l, "stderr")
+	stdout.Write([]byte("hel"))
+	stderr.Write([]byte("oops\n"))
+	stdout.Write([]byte("lo\nworld"))
+	stdout.Close()
+	l.Close()
+
+	var got []*Message
+	if err := ReadLogs(JSONFileDriver, path, ReadConfig{Tail: -1}, func(msg *Message) error {
+		got = append(got, msg)
+		return nil
+	}); err != nil {
+		t.Fatalf("read logs error %v", err)
+	}
+	expect := []struct{ line, source string }{
+		{"oops\n", "stderr"},
+		{"hello\n", "stdout"},
+		{"world", "stdout"},
+	}
+	if len(got) != len(expect) {
+		t.Fatalf("expect %d messages, got %d", len(expect), len(got))
+	}
+	for i, e := range expect {
+		if string(got[i].Line) != e.line || got[i].Source != e.source || got[i].Timestamp.IsZero() {
+			t.Fatalf("message %d: expect %q %s, got %q %s %v", i, e.line, e.source, got[i].Line, got[i].Source, got[i].Timestamp)
+		}
+	}
+}
+
+func TestRawRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container.log")
+	l, err := New(RawDriver, Info{LogPath: path})
+	if err != nil {
+		t.Fatalf("new logger error %v", err)
+	}
+	w := NewLineWriter(l, "stderr")
+	w.Write([]byte("a\nb"))
+	w.Close()
+	l.Close()
+
+	var content string
+	if err := ReadLogs("", path, ReadConfig{Tail: -1}, func(msg *Message) error {
+		content += string(msg.Line)
+		return nil
+	}); err != nil {
+		t.Fatalf("read logs error %v", err)
+	}
+	if content != "a\nb" {
+		t.Fatalf("expect %q, got %q", "a\nb", content)
+	}
+}
+
+func TestTailAndTimeFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container.log")
+	l, err := New(JSONFileDriver, Info{LogPath: path})
+	if err != nil {
+		t.Fatalf("new logger error %v", err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		l.Log(&Message{
+			Line:      []byte(fmt.Sprintf("line %d\n", i)),
+			Source:    "stdout",
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		})
+	}
+	l.Close()
+
+	read := func(config ReadConfig) string {
+		var lines []string
+		if err := ReadLogs(JSONFileDriver, path, config, func(msg *Message) error {
+			lines = append(lines, strings.TrimSpace(string(msg.Line)))
+			return nil
+		}); err != nil {
+			t.Fatalf("read logs error %v", err)
+		}
+		return strings.Join(lines, ",")
+	}
+
+	if got := read(ReadConfig{Tail: 3}); got != "line 7,line 8,line 9" {
+		t.Fatalf("tail 3: got %q", got)
+	}
+	if got := read(ReadConfig{Tail: 0}); got != "" {
+		t.Fatalf("tail 0: got %q", got)
+	}
+	if got := read(ReadConfig{Tail: 100}); !strings.HasPrefix(got, "line 0,") {
+		t.Fatalf("tail 100: got %q", got)
+	}
+	since := base.Add(2 * time.Second)
+	until := base.Add(4 * time.Second)
+	if got := read(ReadConfig{Tail: -1, Since: since, Until: until}); got != "line 2,line 3,line 4" {
+		t.Fatalf("since/until: got %q", got)
+	}
+}
+
+func TestRotateAndReadAcrossFiles(t *testing.T) {
+	for _, compress := range []string{"false", "true"} {
+		path := filepath.Join(t.TempDir(), "container.log")
+		l, err := New(JSONFileDriver, Info{LogPath: path, Config: map[string]string{
+			OptMaxSize:  "1k",
+			OptMaxFile:  "3",
+			OptCompress: compress,
+		}})
+		if err != nil {
+			t.Fatalf("new logger error %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			l.Log(&Message{Line: []byte(fmt.Sprintf("line %d\n", i)), Source: "stdout", Timestamp: time.Now()})
+		}
+		l.Close()
+
+		files := logFiles(path)
+		if len(files) != 3 {
+			t.Fatalf("compress %s: expect 3 files, got %v", compress, files)
+		}
+		if compress == "true" && !strings.HasSuffix(files[0], compressSuffix) {
+			t.Fatalf("rotated file %s is not compressed", files[0])
+		}
+		var lines []string
+		ReadLogs(JSONFileDriver, path, ReadConfig{Tail: -1}, func(msg *Message) error {
+			lines = append(lines, strings.TrimSpace(string(msg.Line)))
+			return nil
+		})
+		if len(lines) == 0 || lines[len(lines)-1] != "line 99" {
+			t.Fatalf("compress %s: unexpected lines %v", compress, lines)
+		}
+		// 读出来的日志应该是连续的
+		first := 100 - len(lines)
+		for i, line := range lines {
+			if line != fmt.Sprintf("line %d", first+i) {
+				t.Fatalf("compress %s: line %d is %q", compress, i, line)
+			}
+		}
+
+		// tail 的行数超过当前文件时需要从轮转的文件中读
+		var tail []string
+		n := len(lines) - 2
+		ReadLogs(JSONFileDriver, path, ReadConfig{Tail: n}, func(msg *Message) error {
+			tail = append(tail, strings.TrimSpace(string(msg.Line)))
+			return nil
+		})
+		if len(tail) != n || tail[0] != lines[2] {
+			t.Fatalf("compress %s: tail %d got %d lines starting %v", compress, n, len(tail), tail[:1])
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package logger
+
+const NoneDriver = "none"
+
+func init() {
+	register(NoneDriver, func(Info) (Logger, error) {
+		return noneLogger{}, nil
+	}, func(opts map[string]string) error {
+		return validateKeys(NoneDriver, opts)
+	}, false)
+}
+
+// 丢弃容器的所有输出
+type noneLogger struct{}
+
+func (noneLogger) Log(*Message) error { return nil }
+
+func (noneLogger) Close() error { return nil }
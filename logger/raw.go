@@ -0,0 +1,26 @@
+package logger
+
+func init() {
+	register(RawDriver, newRawLogger, validateFileOpts, true)
+}
+
+type rawLogger struct {
+	file *rotatingFile
+}
+
+func newRawLogger(info Info) (Logger, error) {
+	file, err := openLogFile(info)
+	if err != nil {
+		return nil, err
+	}
+	return &rawLogger{file: file}, nil
+}
+
+func (l *rawLogger) Log(msg *Message) error {
+	_, err := l.file.Write(msg.Line)
+	return err
+}
+
+func (l *rawLogger) Close() error {
+	return l.file.Close()
+}
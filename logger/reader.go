@@ -0,0 +1,334 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// follow 模式下检查新日志的间隔
+const followInterval = 200 * time.Millisecond
+
+// 读取日志时的过滤条件
+type ReadConfig struct {
+	Tail   int       // 只输出最后 Tail 行, 小于 0 表示全部输出
+	Since  time.Time // 只输出这个时间之后的日志, 零值表示不限制
+	Until  time.Time // 只输出这个时间之前的日志, 零值表示不限制
+	Follow bool      // 读到文件末尾后继续等待新的日志
+	// follow 模式下每次读到文件末尾时调用, 返回 true 表示容器已经退出
+	// 把剩下的日志读完后返回
+	Stopped func() bool
+}
+
+type logReader struct {
+	driver string
+	config ReadConfig
+	handle func(*Message) error
+}
+
+// 从读取开始的位置: 第 index 个文件, 对未压缩的文件 seek 到 offset, 对压缩文件跳过 skip 行
+type readPosition struct {
+	index  int
+	offset int64
+	skip   int
+}
+
+// 按从旧到新的顺序读取 path 以及轮转出去的文件, 对每一条符合条件的日志调用 handle
+// raw 格式没有时间戳和输出流信息, 每一行都当作 stdout, 也不按时间过滤
+func ReadLogs(driver, path string, config ReadConfig, handle func(*Message) error) error {
+	files := logFiles(path)
+	pos := readPosition{}
+	if config.Tail >= 0 {
+		var err error
+		if pos, err = tailPosition(files, config.Tail); err != nil {
+			return err
+		}
+	}
+
+	r := &logReader{driver: driver, config: config, handle: handle}
+	for i := pos.index; i < len(files)-1; i++ {
+		done, err := r.readRotated(files[i], pos)
+		if err != nil && !os.IsNotExist(err) {
+			// 读的过程中文件可能刚好被轮转删掉, 跳过即可
+			return err
+		}
+		if done {
+			return nil
+		}
+		pos = readPosition{}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	if pos.offset > 0 {
+		if _, err := file.Seek(pos.offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	return r.readCurrent(&file, path)
+}
+
+// 轮转出去的文件不会再有新的写入, 读到末尾即可
+func (r *logReader) readRotated(name string, pos readPosition) (bool, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if strings.HasSuffix(name, compressSuffix) {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return false, err
+		}
+		defer zr.Close()
+		reader = zr
+	} else if pos.offset > 0 {
+		if _, err := file.Seek(pos.offset, io.SeekStart); err != nil {
+			return false, err
+		}
+	}
+
+	br := bufio.NewReader(reader)
+	skip := pos.skip
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if skip > 0 {
+				skip--
+			} else if done, handleErr := r.handleLine(line); handleErr != nil || done {
+				return true, handleErr
+			}
+		}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// 读取正在写入的日志文件, follow 模式下文件被轮转后切换到新创建的文件
+func (r *logReader) readCurrent(file **os.File, path string) error {
+	reader := bufio.NewReader(*file)
+	var partial []byte
+	stopped := false
+	rotated := false
+	for {
+		line, err := reader.ReadBytes('\n')
+		partial = append(partial, line...)
+		if err == nil {
+			done, handleErr := r.handleLine(partial)
+			partial = nil
+			if handleErr != nil || done {
+				return handleErr
+			}
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		// 读到文件末尾, 不再等待时输出最后一行不完整的日志
+		if !r.config.Follow || stopped {
+			if len(partial) > 0 {
+				_, err := r.handleLine(partial)
+				return err
+			}
+			return nil
+		}
+		// 文件已经被轮转, 并且旧文件已经读完, 切换到新文件
+		if rotated {
+			next, err := os.Open(path)
+			if err == nil {
+				if len(partial) > 0 {
+					if _, err := r.handleLine(partial); err != nil {
+						next.Close()
+						return err
+					}
+					partial = nil
+				}
+				(*file).Close()
+				*file = next
+				reader.Reset(next)
+				rotated = false
+				continue
+			}
+		} else if isRotated(*file, path) {
+			// 轮转之前写入的内容还在旧文件中, 再读一次旧文件
+			rotated = true
+			continue
+		}
+		// 容器退出后再读一次, 保证退出前写入的日志都被输出
+		if r.config.Stopped != nil && r.config.Stopped() {
+			stopped = true
+			continue
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+// path 已经不再指向正在读取的文件
+func isRotated(file *os.File, path string) bool {
+	current, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	latest, err := os.Stat(path)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	return !os.SameFile(current, latest)
+}
+
+// 解析并过滤一行日志, 返回 true 表示已经超过 Until, 不需要再往后读
+func (r *logReader) handleLine(line []byte) (bool, error) {
+	msg, err := decodeLine(r.driver, line)
+	if err != nil {
+		// 跳过损坏的行, 例如写日志时进程被杀掉留下的半行
+		return false, nil
+	}
+	if !msg.Timestamp.IsZero() {
+		if !r.config.Since.IsZero() && msg.Timestamp.Before(r.config.Since) {
+			return false, nil
+		}
+		if !r.config.Until.IsZero() && msg.Timestamp.After(r.config.Until) {
+			return true, nil
+		}
+	}
+	return false, r.handle(msg)
+}
+
+func decodeLine(driver string, line []byte) (*Message, error) {
+	if driver == JSONFileDriver {
+		return decodeJSONLog(line)
+	}
+	return &Message{Line: line, Source: "stdout"}, nil
+}
+
+// 按从旧到新的顺序返回所有日志文件, 最后一个是正在写入的 path
+func logFiles(path string) []string {
+	var rotated []string
+	for i := 1; ; i++ {
+		name, ok := rotatedName(path, i)
+		if !ok {
+			break
+		}
+		rotated = append(rotated, name)
+	}
+	files := make([]string, 0, len(rotated)+1)
+	for i := len(rotated) - 1; i >= 0; i-- {
+		files = append(files, rotated[i])
+	}
+	return append(files, path)
+}
+
+// 从最新的文件往前找最后 n 行开始的位置
+func tailPosition(files []string, n int) (readPosition, error) {
+	remaining := n
+	for i := len(files) - 1; i >= 0; i-- {
+		if strings.HasSuffix(files[i], compressSuffix) {
+			// 压缩文件不能从末尾往前读, 只能先数一遍行数
+			count, err := countLines(files[i])
+			if err != nil {
+				return readPosition{}, err
+			}
+			if count >= remaining {
+				return readPosition{index: i, skip: count - remaining}, nil
+			}
+			remaining -= count
+			continue
+		}
+		file, err := os.Open(files[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return readPosition{}, err
+		}
+		offset, count, err := tailOffset(file, remaining)
+		file.Close()
+		if err != nil {
+			return readPosition{}, err
+		}
+		if count >= remaining {
+			return readPosition{index: i, offset: offset}, nil
+		}
+		remaining -= count
+	}
+	return readPosition{}, nil
+}
+
+func countLines(name string) (int, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+	count := 0
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			count++
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// 从文件末尾往前按块查找, 返回最后 n 行的起始位置和找到的行数, 不需要把整个文件读进内存
+// 文件不足 n 行时返回 0 和文件的总行数
+func tailOffset(file *os.File, n int) (int64, int, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := stat.Size()
+	if n == 0 {
+		return size, 0, nil
+	}
+	buf := make([]byte, 4096)
+	count := 0
+	pos := size
+	for pos > 0 {
+		readSize := int64(len(buf))
+		if pos < readSize {
+			readSize = pos
+		}
+		pos -= readSize
+		if _, err := file.ReadAt(buf[:readSize], pos); err != nil {
+			return 0, 0, err
+		}
+		for i := readSize - 1; i >= 0; i-- {
+			// 文件末尾的换行符是最后一行的结尾, 不算作分隔
+			if buf[i] != '\n' || pos+i == size-1 {
+				continue
+			}
+			count++
+			if count == n {
+				return pos + i + 1, count, nil
+			}
+		}
+	}
+	// 第一行前面没有换行符
+	if size > 0 {
+		count++
+	}
+	return 0, count, nil
+}
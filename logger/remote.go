@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 连接和写入远端日志服务的超时时间, 日志服务卡住时不能拖住容器的输出
+const remoteTimeout = 5 * time.Second
+
+// 解析 tcp://host:port, udp://host:port, unix:///path 形式的地址
+func parseAddress(address string, schemes ...string) (network, addr string, err error) {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme == "" {
+		return "", "", fmt.Errorf("invalid address %s", address)
+	}
+	supported := false
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return "", "", fmt.Errorf("unsupported scheme %s in address %s, must be one of %s", u.Scheme, address, strings.Join(schemes, ", "))
+	}
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid address %s", address)
+		}
+		return u.Scheme, u.Path, nil
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return "", "", fmt.Errorf("invalid address %s: %v", address, err)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// 到远端日志服务的连接, 写入失败时重连一次再重试
+type remoteConn struct {
+	sync.Mutex
+	network string
+	addr    string
+	conn    net.Conn
+}
+
+func dialRemote(network, addr string) (*remoteConn, error) {
+	r := &remoteConn{network: network, addr: addr}
+	if err := r.dial(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *remoteConn) dial() error {
+	conn, err := net.DialTimeout(r.network, r.addr, remoteTimeout)
+	if err != nil {
+		return fmt.Errorf("connect to log server %s://%s error %v", r.network, r.addr, err)
+	}
+	r.conn = conn
+	return nil
+}
+
+func (r *remoteConn) Write(p []byte) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.conn != nil {
+		_ = r.conn.SetWriteDeadline(time.Now().Add(remoteTimeout))
+		if _, err := r.conn.Write(p); err == nil {
+			return nil
+		}
+		r.conn.Close()
+		r.conn = nil
+	}
+	if err := r.dial(); err != nil {
+		return err
+	}
+	_ = r.conn.SetWriteDeadline(time.Now().Add(remoteTimeout))
+	_, err := r.conn.Write(p)
+	return err
+}
+
+func (r *remoteConn) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// 宿主机的主机名, 获取失败时返回 syslog 规定的空值 "-"
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "-"
+	}
+	return name
+}
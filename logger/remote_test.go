@@ -0,0 +1,207 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testInfo = Info{
+	ContainerID:   "0123456789abcdef0123456789abcdef",
+	ContainerName: "web",
+}
+
+func testMessage(line, source string) *Message {
+	return &Message{
+		Line:      []byte(line),
+		Source:    source,
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+	}
+}
+
+// 接受一个 TCP 连接, 把收到的数据按 delim 切分后发到 channel
+func listenTCP(t *testing.T, delim byte) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan string, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			data, err := r.ReadString(delim)
+			if err != nil {
+				return
+			}
+			ch <- strings.TrimSuffix(data, string(delim))
+		}
+	}()
+	return "tcp://" + l.Addr().String(), ch
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	select {
+	case data := <-ch:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for log message")
+	}
+	return ""
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error %v", err)
+	}
+	defer conn.Close()
+
+	info := testInfo
+	info.Config = map[string]string{"syslog-address": "udp://" + conn.LocalAddr().String(), "syslog-facility": "local0"}
+	l, err := New(SyslogDriver, info)
+	if err != nil {
+		t.Fatalf("new syslog logger error %v", err)
+	}
+	defer l.Close()
+	if err := l.Log(testMessage("boom\n", "stderr")); err != nil {
+		t.Fatalf("log error %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read error %v", err)
+	}
+	got := string(buf[:n])
+	// local0 (16) * 8 + err (3)
+	if !strings.HasPrefix(got, "<131>1 2024-01-02T03:04:05.000006Z ") || !strings.Contains(got, " 0123456789ab ") || !strings.HasSuffix(got, " - - boom") {
+		t.Fatalf("unexpected syslog message %q", got)
+	}
+}
+
+func TestSyslogUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatalf("listen error %v", err)
+	}
+	defer conn.Close()
+
+	info := testInfo
+	info.Config = map[string]string{"syslog-address": "unix://" + path, "tag": "myapp"}
+	l, err := New(SyslogDriver, info)
+	if err != nil {
+		t.Fatalf("new syslog logger error %v", err)
+	}
+	defer l.Close()
+	l.Log(testMessage("hello\n", "stdout"))
+
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read error %v", err)
+	}
+	got := string(buf[:n])
+	if !strings.HasPrefix(got, "<30>1 ") || !strings.Contains(got, " myapp ") || !strings.HasSuffix(got, " - - hello") {
+		t.Fatalf("unexpected syslog message %q", got)
+	}
+}
+
+func TestSyslogTCPOctetCounting(t *testing.T) {
+	address, ch := listenTCP(t, ' ')
+	info := testInfo
+	info.Config = map[string]string{"syslog-address": address}
+	l, err := New(SyslogDriver, info)
+	if err != nil {
+		t.Fatalf("new syslog logger error %v", err)
+	}
+	defer l.Close()
+	msg := testMessage("hello\n", "stdout")
+	l.Log(msg)
+
+	// 帧的开头是消息的长度
+	expect := formatRFC5424(syslogFacilities[defaultFacility], msg, hostname(), testInfo.Tag(), os.Getpid())
+	if got := receive(t, ch); got != strconv.Itoa(len(expect)) {
+		t.Fatalf("expect frame length %d, got %q", len(expect), got)
+	}
+}
+
+func TestGelf(t *testing.T) {
+	address, ch := listenTCP(t, '\x00')
+	info := testInfo
+	info.Config = map[string]string{"gelf-address": address}
+	l, err := New(GelfDriver, info)
+	if err != nil {
+		t.Fatalf("new gelf logger error %v", err)
+	}
+	defer l.Close()
+	l.Log(testMessage("hello\n", "stdout"))
+
+	var msg gelfMessage
+	if err := json.Unmarshal([]byte(receive(t, ch)), &msg); err != nil {
+		t.Fatalf("decode gelf message error %v", err)
+	}
+	if msg.Version != "1.1" || msg.ShortMessage != "hello" || msg.Level != severityInfo || msg.ContainerName != "web" {
+		t.Fatalf("unexpected gelf message %+v", msg)
+	}
+}
+
+func TestFluentd(t *testing.T) {
+	address, ch := listenTCP(t, ']')
+	info := testInfo
+	info.Config = map[string]string{"fluentd-address": strings.TrimPrefix(address, "tcp://"), "tag": "docker.web"}
+	l, err := New(FluentdDriver, info)
+	if err != nil {
+		t.Fatalf("new fluentd logger error %v", err)
+	}
+	defer l.Close()
+	l.Log(testMessage("oops\n", "stderr"))
+
+	var entry []json.RawMessage
+	if err := json.Unmarshal([]byte(receive(t, ch)+"]"), &entry); err != nil || len(entry) != 3 {
+		t.Fatalf("decode fluentd entry error %v", err)
+	}
+	var tag string
+	var record fluentdRecord
+	json.Unmarshal(entry[0], &tag)
+	json.Unmarshal(entry[2], &record)
+	if tag != "docker.web" || record.Log != "oops" || record.Source != "stderr" {
+		t.Fatalf("unexpected fluentd entry %s %+v", tag, record)
+	}
+}
+
+func TestValidateOpts(t *testing.T) {
+	cases := []struct {
+		driver string
+		opts   map[string]string
+		ok     bool
+	}{
+		{JSONFileDriver, map[string]string{"max-size": "10m", "max-file": "3"}, true},
+		{JSONFileDriver, map[string]string{"max-file": "3"}, false},
+		{NoneDriver, nil, true},
+		{NoneDriver, map[string]string{"max-size": "10m"}, false},
+		{GelfDriver, nil, false},
+		{GelfDriver, map[string]string{"gelf-address": "udp://127.0.0.1:12201"}, false},
+		{SyslogDriver, map[string]string{"syslog-facility": "nope"}, false},
+		{"journald", nil, false},
+	}
+	for _, c := range cases {
+		if err := ValidateOpts(c.driver, c.opts); (err == nil) != c.ok {
+			t.Fatalf("validate %s %v: expect ok=%v, got %v", c.driver, c.opts, c.ok, err)
+		}
+	}
+}
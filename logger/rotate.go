@@ -0,0 +1,318 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mydocker/store"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	OptMaxSize  = "max-size"
+	OptMaxFile  = "max-file"
+	OptCompress = "compress"
+
+	compressSuffix    = ".gz"
+	compressTmpSuffix = ".tmp"
+)
+
+// 日志文件的轮转配置, 由 --log-opt 解析得到
+type rotateConfig struct {
+	maxSize  int64 // 单个文件的最大字节数, 小于等于 0 表示不轮转
+	maxFiles int   // 包括当前文件在内最多保留的文件数
+	compress bool  // 是否 gzip 压缩轮转出去的文件
+}
+
+// 把 --log-opt key=value 参数解析成 map
+func ParseOpts(values []string) (map[string]string, error) {
+	opts := map[string]string{}
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid log opt %s, must be key=value", value)
+		}
+		opts[kv[0]] = kv[1]
+	}
+	return opts, nil
+}
+
+// 写本地文件的日志驱动共用的参数检查
+func validateFileOpts(opts map[string]string) error {
+	_, err := parseRotateConfig(opts)
+	return err
+}
+
+func openLogFile(info Info) (*rotatingFile, error) {
+	config, err := parseRotateConfig(info.Config)
+	if err != nil {
+		return nil, err
+	}
+	return openRotatingFile(info.LogPath, config)
+}
+
+func parseRotateConfig(opts map[string]string) (*rotateConfig, error) {
+	config := &rotateConfig{maxSize: -1, maxFiles: 1}
+	for key, value := range opts {
+		switch key {
+		case OptMaxSize:
+			size, err := parseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			config.maxSize = size
+		case OptMaxFile:
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s %s, must be a positive integer", OptMaxFile, value)
+			}
+			config.maxFiles = n
+		case OptCompress:
+			compress, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %s", OptCompress, value)
+			}
+			config.compress = compress
+		default:
+			return nil, fmt.Errorf("unknown log opt %s", key)
+		}
+	}
+	if config.maxSize <= 0 && (opts[OptMaxFile] != "" || opts[OptCompress] != "") {
+		return nil, fmt.Errorf("%s and %s require %s", OptMaxFile, OptCompress, OptMaxSize)
+	}
+	return config, nil
+}
+
+// 解析 10k, 10m, 1g 这样的大小, 不带单位时为字节
+func parseSize(value string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "b")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "m"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "g"):
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %s", OptMaxSize, value)
+	}
+	return n * unit, nil
+}
+
+// 会按大小轮转的日志文件
+// 轮转后的文件为 path.1, path.2 ..., 数字越大越旧, 压缩后加上 .gz 后缀
+// 容器的 monitor 和 exec -d 的进程可能同时写同一个文件, 大小以文件的实际大小为准
+// 轮转时持有 path.lock 文件锁, 发现文件已经被其他进程轮转后重新打开
+// 压缩在后台进行, 不阻塞日志的写入, 压缩完成之前一直持有文件锁, 其他的轮转会等待压缩完成
+type rotatingFile struct {
+	path   string
+	config *rotateConfig
+	file   *os.File
+
+	compressing chan struct{} // 后台压缩完成后关闭, 没有压缩时为 nil
+	compressErr error
+}
+
+func openRotatingFile(path string, config *rotateConfig) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:   path,
+		config: config,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	// 容器重启后继续追加日志, 而不是清空之前的日志
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	return nil
+}
+
+// 每次写入的都是完整的一条日志, 超过大小限制时先轮转再写, 日志不会被拆到两个文件中
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.config.maxSize > 0 {
+		if err := f.rotateIfNeeded(int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	return f.file.Write(p)
+}
+
+// 等待后台压缩完成, 避免 monitor 进程退出时留下压缩了一半的文件
+func (f *rotatingFile) Close() error {
+	err := f.file.Close()
+	if compressErr := f.waitCompress(); err == nil {
+		err = compressErr
+	}
+	return err
+}
+
+func (f *rotatingFile) waitCompress() error {
+	if f.compressing == nil {
+		return nil
+	}
+	<-f.compressing
+	f.compressing = nil
+	return f.compressErr
+}
+
+func (f *rotatingFile) needRotate(n int64) (bool, error) {
+	if err := f.reopenIfRotated(); err != nil {
+		return false, err
+	}
+	stat, err := f.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	return stat.Size() > 0 && stat.Size()+n > f.config.maxSize, nil
+}
+
+func (f *rotatingFile) rotateIfNeeded(n int64) error {
+	if need, err := f.needRotate(n); err != nil || !need {
+		return err
+	}
+	// 上一次的压缩失败时轮转出去的文件没有压缩, 依然可以读取, 不影响这次轮转
+	_ = f.waitCompress()
+	lock, err := store.Lock(f.path + ".lock")
+	if err != nil {
+		return err
+	}
+	// 等锁的时候其他进程可能已经轮转过了
+	if need, err := f.needRotate(n); err != nil || !need {
+		lock.Unlock()
+		return err
+	}
+	compress, err := f.rotate()
+	if compress == "" {
+		lock.Unlock()
+		return err
+	}
+	// 压缩完成后才释放锁, 这期间其他轮转不会挪动或删除正在压缩的文件
+	done := make(chan struct{})
+	f.compressing = done
+	go func() {
+		defer close(done)
+		defer lock.Unlock()
+		if err := compressFile(compress); err != nil {
+			f.compressErr = fmt.Errorf("compress %s error %v", compress, err)
+		}
+	}()
+	return err
+}
+
+// path 已经被其他进程轮转走时重新打开
+func (f *rotatingFile) reopenIfRotated() error {
+	current, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	latest, err := os.Stat(f.path)
+	if err == nil && os.SameFile(current, latest) {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f.file.Close()
+	return f.open()
+}
+
+// 返回需要压缩的文件, 由调用者在后台压缩
+func (f *rotatingFile) rotate() (string, error) {
+	if err := f.file.Close(); err != nil {
+		return "", err
+	}
+	var compress string
+	maxRotated := f.config.maxFiles - 1
+	if maxRotated > 0 {
+		// 删除最旧的文件, 其余的依次往后挪一位
+		removeRotated(f.path, maxRotated)
+		for i := maxRotated - 1; i >= 1; i-- {
+			if name, ok := rotatedName(f.path, i); ok {
+				suffix := strings.TrimPrefix(name, fmt.Sprintf("%s.%d", f.path, i))
+				_ = os.Rename(name, fmt.Sprintf("%s.%d%s", f.path, i+1, suffix))
+			}
+		}
+		first := f.path + ".1"
+		if err := os.Rename(f.path, first); err != nil {
+			return "", err
+		}
+		if f.config.compress {
+			compress = first
+		}
+	} else {
+		// max-file=1 时不保留旧日志, 正在 follow 的读者仍然可以读完已经删除的文件
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return compress, f.open()
+}
+
+// 返回第 i 个轮转文件的实际文件名, 压缩和未压缩的同时存在时只返回其中一个
+func rotatedName(path string, i int) (string, bool) {
+	name := fmt.Sprintf("%s.%d", path, i)
+	if _, err := os.Stat(name); err == nil {
+		return name, true
+	}
+	if _, err := os.Stat(name + compressSuffix); err == nil {
+		return name + compressSuffix, true
+	}
+	return "", false
+}
+
+// 压缩中途退出的进程可能留下临时文件, 一并删除
+func removeRotated(path string, i int) {
+	name := fmt.Sprintf("%s.%d", path, i)
+	_ = os.Remove(name)
+	_ = os.Remove(name + compressSuffix)
+	_ = os.Remove(name + compressSuffix + compressTmpSuffix)
+}
+
+// 先压缩到临时文件再 rename, 读日志的一方不会看到压缩了一半的文件
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpName := name + compressSuffix + compressTmpSuffix
+	dst, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName)
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, name+compressSuffix); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,142 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SyslogDriver = "syslog"
+
+	defaultSyslogAddress = "unix:///dev/log"
+	defaultFacility      = "daemon"
+
+	severityErr  = 3
+	severityInfo = 6
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+func init() {
+	register(SyslogDriver, newSyslogLogger, validateSyslogOpts, false)
+}
+
+// 按 RFC5424 格式发送到 syslog, stdout 的严重级别为 info, stderr 为 err
+// 数据报 socket 和 udp 每条日志一个数据报, tcp 按 RFC6587 的 octet counting 分帧, 流式 unix socket 以换行分隔
+type syslogLogger struct {
+	conn     *remoteConn
+	network  string
+	facility int
+	hostname string
+	tag      string
+	pid      int
+}
+
+func validateSyslogOpts(opts map[string]string) error {
+	if err := validateKeys(SyslogDriver, opts, "syslog-address", "syslog-facility", "tag"); err != nil {
+		return err
+	}
+	if address := opts["syslog-address"]; address != "" {
+		if _, _, err := parseAddress(address, "unix", "unixgram", "udp", "tcp"); err != nil {
+			return err
+		}
+	}
+	if facility := opts["syslog-facility"]; facility != "" {
+		if _, ok := syslogFacilities[facility]; !ok {
+			return fmt.Errorf("invalid syslog facility %s", facility)
+		}
+	}
+	return nil
+}
+
+func newSyslogLogger(info Info) (Logger, error) {
+	address := info.Config["syslog-address"]
+	if address == "" {
+		address = defaultSyslogAddress
+	}
+	network, addr, err := parseAddress(address, "unix", "unixgram", "udp", "tcp")
+	if err != nil {
+		return nil, err
+	}
+	// /dev/log 通常是数据报 socket, 连不上时再尝试流式 socket
+	var conn *remoteConn
+	if network == "unix" {
+		if conn, err = dialRemote("unixgram", addr); err == nil {
+			network = "unixgram"
+		}
+	}
+	if conn == nil {
+		if conn, err = dialRemote(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	facility := info.Config["syslog-facility"]
+	if facility == "" {
+		facility = defaultFacility
+	}
+	return &syslogLogger{
+		conn:     conn,
+		network:  network,
+		facility: syslogFacilities[facility],
+		hostname: hostname(),
+		tag:      info.Tag(),
+		pid:      os.Getpid(),
+	}, nil
+}
+
+func (l *syslogLogger) Log(msg *Message) error {
+	data := formatRFC5424(l.facility, msg, l.hostname, l.tag, l.pid)
+	switch l.network {
+	case "tcp":
+		data = append([]byte(fmt.Sprintf("%d ", len(data))), data...)
+	case "unix":
+		data = append(data, '\n')
+	}
+	return l.conn.Write(data)
+}
+
+func (l *syslogLogger) Close() error {
+	return l.conn.Close()
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func formatRFC5424(facility int, msg *Message, hostname, tag string, pid int) []byte {
+	severity := severityInfo
+	if msg.Source == "stderr" {
+		severity = severityErr
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d - - ",
+		facility*8+severity,
+		msg.Timestamp.UTC().Format(time.RFC3339Nano),
+		headerField(hostname, 255),
+		headerField(tag, 48),
+		pid)
+	buf.Write(bytes.TrimRight(msg.Line, "\n"))
+	return buf.Bytes()
+}
+
+// RFC5424 头部字段只能是可打印的 ASCII 字符, 并且有长度限制
+func headerField(value string, maxLen int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "" {
+		return "-"
+	}
+	if len(value) > maxLen {
+		value = value[:maxLen]
+	}
+	return value
+}
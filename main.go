@@ -13,6 +13,12 @@ write a docker by ourselves
 			   Enjoy it, just for fun.`
 
 func main() {
+	// 设置了 ENV_EXEC_PID 时 nsenter 的 C 构造函数只在进入容器之后 fork 出来的子进程中返回
+	// 参数是要执行的命令, 不经过命令行解析
+	if os.Getenv(ENV_EXEC_PID) != "" {
+		os.Exit(execInContainer())
+	}
+
 	app := cli.NewApp()
 	app.Name = "mydocker"
 	app.Usage = usage
@@ -20,15 +26,37 @@ func main() {
 	app.Commands = []*cli.Command{
 		&initCommand,
 		&runCommand,
+		&monitorCommand,
+		&execMonitorCommand,
+		&recoverCommand,
 		&commitCommand,
 		&listCommand,
 		&logCommand,
 		&execCommand,
+		&topCommand,
+		&copyCommand,
+		&diffCommand,
+		&attachCommand,
+		&inspectCommand,
 		&stopCommand,
+		&waitCommand,
 		&removeCommand,
+		&imagesCommand,
+		&tagCommand,
+		&removeImageCommand,
+		&loadCommand,
+		&saveCommand,
+		&infoCommand,
 		&networkCommand,
 	}
 
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "storage driver for new containers: overlay2, aufs or vfs, selected automatically if empty",
+		},
+	}
+
 	// 初始化 log
 	app.Before = func(ctx *cli.Context) error {
 		log.SetFormatter(&log.JSONFormatter{})
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"mydocker/cgroups/subsystems"
 	"mydocker/container"
+	"mydocker/graphdriver"
+	"mydocker/image"
+	"mydocker/logger"
 	"mydocker/network"
-	"os"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -14,7 +16,7 @@ import (
 var runCommand = cli.Command{
 	Name: "run",
 	Usage: `Create a container with namespace and cgroups limit
-			mydocker run -ti [command]`,
+			mydocker run -ti image [command]`,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:  "ti",
@@ -24,6 +26,10 @@ var runCommand = cli.Command{
 			Name:  "d",
 			Usage: "detach container",
 		},
+		&cli.BoolFlag{
+			Name:  "i",
+			Usage: "keep stdin open for mydocker attach (detached containers)",
+		},
 		&cli.StringFlag{
 			Name:  "m",
 			Usage: "memory limit",
@@ -49,6 +55,10 @@ var runCommand = cli.Command{
 			Name:  "e",
 			Usage: "set environment",
 		},
+		&cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read in a file of environment variables",
+		},
 		&cli.StringFlag{
 			Name:  "net",
 			Usage: "container network",
@@ -57,10 +67,54 @@ var runCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		&cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "overwrite the default entrypoint of the image, the image's cmd is not used either",
+		},
+		&cli.BoolFlag{
+			Name:  "P",
+			Usage: "publish all exposed ports of the image to random host ports",
+		},
+		&cli.StringFlag{
+			Name:  "restart",
+			Usage: "restart policy: no|on-failure[:N]|always|unless-stopped",
+		},
+		&cli.StringFlag{
+			Name:  "log-driver",
+			Usage: "logging driver for the container: json-file|raw|none|syslog|gelf|fluentd",
+			Value: logger.DefaultDriver,
+		},
+		&cli.StringSliceFlag{
+			Name:  "log-opt",
+			Usage: "log driver options, e.g. max-size=10m, max-file=3, syslog-address=udp://host:514, gelf-address=tcp://host:12201",
+		},
+		&cli.StringFlag{
+			Name:  "health-cmd",
+			Usage: "command to run inside the container to check health",
+		},
+		&cli.DurationFlag{
+			Name:  "health-interval",
+			Usage: "time between running the check",
+			Value: container.DefaultHealthInterval,
+		},
+		&cli.DurationFlag{
+			Name:  "health-timeout",
+			Usage: "maximum time to allow one check to run",
+			Value: container.DefaultHealthTimeout,
+		},
+		&cli.IntFlag{
+			Name:  "health-retries",
+			Usage: "consecutive failures needed to report unhealthy",
+			Value: container.DefaultHealthRetries,
+		},
+		&cli.DurationFlag{
+			Name:  "health-start-period",
+			Usage: "start period for the container to initialize before counting retries",
+		},
 	},
 	Action: func(ctx *cli.Context) error {
 		if ctx.NArg() < 1 {
-			return fmt.Errorf("Missing container command")
+			return fmt.Errorf("Missing image name")
 		}
 		cmdArray := ctx.Args().Slice()
 		imageName := cmdArray[0]
@@ -77,14 +131,105 @@ var runCommand = cli.Command{
 			CpuSet:      ctx.String("cpuset"),
 			CpuShare:    ctx.String("cpushare"),
 		}
+		restartPolicy, err := container.ParseRestartPolicy(ctx.String("restart"))
+		if err != nil {
+			return err
+		}
+		logOpts, err := logger.ParseOpts(ctx.StringSlice("log-opt"))
+		if err != nil {
+			return err
+		}
+		if err := logger.ValidateOpts(ctx.String("log-driver"), logOpts); err != nil {
+			return err
+		}
+		// 全局参数 --storage-driver, 没有指定时自动选择
+		storageDriver, err := graphdriver.New(ctx.String("storage-driver"))
+		if err != nil {
+			return err
+		}
 
-		containerName := ctx.String("name")
-		volume := ctx.String("v")
-		network := ctx.String("net")
+		// 容器的环境变量依次为镜像的配置, --env-file, -e, 不继承宿主机的环境变量
+		imageID, imageConfig, err := image.LoadConfig(imageName)
+		if err != nil {
+			return err
+		}
+		var fileEnv []string
+		for _, envFile := range ctx.StringSlice("env-file") {
+			env, err := container.ParseEnvFile(envFile)
+			if err != nil {
+				return fmt.Errorf("read env file %s error %v", envFile, err)
+			}
+			fileEnv = append(fileEnv, env...)
+		}
+		env := container.MergeEnv(imageConfig.Env, fileEnv, container.ExpandEnv(ctx.StringSlice("e")))
 
-		envSlice := ctx.StringSlice("e")
-		portmapping := ctx.StringSlice("p")
-		Run(tty, cmdArray, resConf, containerName, volume, imageName, envSlice, network, portmapping)
+		// 没有指定命令时使用镜像的 Cmd, --entrypoint 覆盖镜像的 Entrypoint, 同时不再使用镜像的 Cmd
+		entrypoint, cmd := imageConfig.Entrypoint, imageConfig.Cmd
+		if ctx.IsSet("entrypoint") {
+			entrypoint, cmd = nil, nil
+			if ctx.String("entrypoint") != "" {
+				entrypoint = []string{ctx.String("entrypoint")}
+			}
+		}
+		if len(cmdArray) > 0 {
+			cmd = cmdArray
+		}
+		cmdArray = append(append([]string(nil), entrypoint...), cmd...)
+		if len(cmdArray) == 0 {
+			return fmt.Errorf("Missing container command, image %s has no default command", imageName)
+		}
+		if _, err := container.ParseSignal(imageConfig.StopSignal); err != nil {
+			return err
+		}
+		exposedPorts := imageConfig.ExposedPortList()
+		portMapping := ctx.StringSlice("p")
+		if ctx.Bool("P") {
+			if portMapping, err = publishPorts(exposedPorts, portMapping); err != nil {
+				return err
+			}
+		}
+
+		info := &container.ContainerInfo{
+			Name:           ctx.String("name"),
+			CmdArray:       cmdArray,
+			Entrypoint:     entrypoint,
+			Volume:         ctx.String("v"),
+			PortMapping:    portMapping,
+			Image:          imageName,
+			ImageID:        imageID,
+			Env:            env,
+			WorkingDir:     imageConfig.WorkingDir,
+			User:           imageConfig.User,
+			StopSignal:     imageConfig.StopSignal,
+			ExposedPorts:   exposedPorts,
+			Network:        ctx.String("net"),
+			ResourceConfig: resConf,
+			TTY:            tty,
+			OpenStdin:      ctx.Bool("i") && !tty,
+			RestartPolicy:  restartPolicy,
+			LogConfig:      container.LogConfig{Type: ctx.String("log-driver"), Config: logOpts},
+			StorageDriver:  storageDriver.String(),
+		}
+		if healthCmd := ctx.String("health-cmd"); healthCmd != "" {
+			if ctx.Duration("health-interval") <= 0 || ctx.Duration("health-timeout") <= 0 || ctx.Int("health-retries") < 1 {
+				return fmt.Errorf("health-interval and health-timeout must be positive, health-retries at least 1")
+			}
+			info.Healthcheck = &container.HealthConfig{
+				Cmd:         healthCmd,
+				Interval:    ctx.Duration("health-interval"),
+				Timeout:     ctx.Duration("health-timeout"),
+				StartPeriod: ctx.Duration("health-start-period"),
+				Retries:     ctx.Int("health-retries"),
+			}
+		}
+		exitCode, err := Run(info)
+		if err != nil {
+			return err
+		}
+		// 把容器的退出码作为 mydocker run 的退出码
+		if exitCode != 0 {
+			return cli.Exit("", exitCode)
+		}
 		return nil
 	},
 }
@@ -100,17 +245,74 @@ var initCommand = cli.Command{
 	},
 }
 
+// 由 mydocker run 在后台启动, 负责容器的启动与重启
+var monitorCommand = cli.Command{
+	Name:   "monitor",
+	Usage:  "Monitor container process and restart it by restart policy. Do not call it outside",
+	Hidden: true,
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		monitorContainer(ctx.Args().Get(0))
+		return nil
+	},
+}
+
+var execMonitorCommand = cli.Command{
+	Name:   "exec-monitor",
+	Usage:  "Run a detached exec session and write its output to the container logs. Do not call it outside",
+	Hidden: true,
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 2 {
+			return fmt.Errorf("Missing container id or exec id")
+		}
+		if err := execMonitor(ctx.Args().Get(0), ctx.Args().Get(1)); err != nil {
+			logrus.Errorf("Exec session %s error %v", ctx.Args().Get(1), err)
+		}
+		return nil
+	},
+}
+
+var recoverCommand = cli.Command{
+	Name:  "recover",
+	Usage: "restart containers with restart policy always or unless-stopped after host reboot",
+	Action: func(ctx *cli.Context) error {
+		recoverContainers()
+		return nil
+	},
+}
+
 var commitCommand = cli.Command{
 	Name:  "commit",
-	Usage: "commit a container into image",
+	Usage: "commit a container into image, usage: commit CONTAINER [REPOSITORY[:TAG]]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "author",
+			Aliases: []string{"a"},
+			Usage:   "author (e.g., \"John Hannibal Smith <hannibal@a-team.com>\")",
+		},
+		&cli.StringFlag{
+			Name:    "message",
+			Aliases: []string{"m"},
+			Usage:   "commit message",
+		},
+		&cli.StringSliceFlag{
+			Name:    "change",
+			Aliases: []string{"c"},
+			Usage:   "apply Dockerfile instruction to the created image, supports CMD, ENV and WORKDIR",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if ctx.NArg() < 1 {
 			return fmt.Errorf("Missing container name")
 		}
 		containerName := ctx.Args().Get(0)
-		imageName := ctx.Args().Get(1)
-		commitContainer(containerName, imageName)
-		return nil
+		return commitContainer(containerName, ctx.Args().Get(1), commitOptions{
+			Author:  ctx.String("author"),
+			Message: ctx.String("message"),
+			Changes: ctx.StringSlice("change"),
+		})
 	},
 }
 
@@ -126,31 +328,194 @@ var listCommand = cli.Command{
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of a container",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "follow",
+			Aliases: []string{"f"},
+			Usage:   "follow log output until the container exits",
+		},
+		&cli.StringFlag{
+			Name:  "tail",
+			Usage: "number of lines to show from the end of the logs",
+			Value: "all",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m for 42 minutes)",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "show logs before a timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m for 42 minutes)",
+		},
+		&cli.BoolFlag{
+			Name:    "timestamps",
+			Aliases: []string{"t"},
+			Usage:   "show timestamps",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if ctx.NArg() < 1 {
 			return fmt.Errorf("Please input your container name")
 		}
 		containerName := ctx.Args().Get(0)
-		logContainer(containerName)
-		return nil
+		opts, err := parseLogsOptions(ctx)
+		if err != nil {
+			return err
+		}
+		return logContainer(containerName, opts)
 	},
 }
 
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "ti",
+			Usage: "allocate a pseudo-TTY and keep stdin open",
+		},
+		&cli.BoolFlag{
+			Name:  "d",
+			Usage: "detached mode: run command in the background, output goes to the container logs",
+		},
+		&cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment variables",
+		},
+		&cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "read in a file of environment variables",
+		},
+		&cli.BoolFlag{
+			Name:  "privileged",
+			Usage: "give the command all capabilities instead of only those of the container process",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
-		if os.Getenv(ENV_EXEC_PID) != "" {
-			logrus.Infof("pid callback pid %v", os.Getgid())
-			return nil
-		}
-
 		if ctx.NArg() < 2 {
 			return fmt.Errorf("Missing container name or command")
 		}
 		containerName := ctx.Args().Get(0)
-		commandArray := ctx.Args().Slice()
-		ExecContainer(containerName, commandArray)
+		commandArray := ctx.Args().Slice()[1:]
+		opts := execOptions{
+			TTY:        ctx.Bool("ti"),
+			Detach:     ctx.Bool("d"),
+			Privileged: ctx.Bool("privileged"),
+		}
+		if opts.TTY && opts.Detach {
+			return fmt.Errorf("ti and d parameter can not both be provided")
+		}
+		for _, envFile := range ctx.StringSlice("env-file") {
+			env, err := container.ParseEnvFile(envFile)
+			if err != nil {
+				return fmt.Errorf("read env file %s error %v", envFile, err)
+			}
+			opts.Env = append(opts.Env, env...)
+		}
+		opts.Env = append(opts.Env, container.ExpandEnv(ctx.StringSlice("e"))...)
+		exitCode, err := ExecContainer(containerName, commandArray, opts)
+		if err != nil {
+			return fmt.Errorf("Exec container %s error %v", containerName, err)
+		}
+		if exitCode != 0 {
+			return cli.Exit("", exitCode)
+		}
+		return nil
+	},
+}
+
+var topCommand = cli.Command{
+	Name:      "top",
+	Usage:     "display the running processes of a container",
+	ArgsUsage: "CONTAINER [ps OPTIONS]",
+	// ps 的参数原样传给 ps, 不当作 mydocker 的参数解析
+	SkipFlagParsing: true,
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return topContainer(ctx.Args().Get(0), ctx.Args().Slice()[1:])
+	},
+}
+
+var copyCommand = cli.Command{
+	Name:      "cp",
+	Usage:     "copy files/folders between a container and the local filesystem",
+	ArgsUsage: "CONTAINER:SRC_PATH DEST_PATH | SRC_PATH CONTAINER:DEST_PATH",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "follow-link",
+			Aliases: []string{"L"},
+			Usage:   "always follow symbol link in SRC_PATH",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() != 2 {
+			return fmt.Errorf("Please input source and destination path")
+		}
+		return copyFiles(ctx.Args().Get(0), ctx.Args().Get(1), ctx.Bool("follow-link"))
+	},
+}
+
+var diffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "inspect changes to files or directories on a container's filesystem",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "output format, json prints [{\"Path\": ..., \"Kind\": 0|1|2}] (0: changed, 1: added, 2: deleted)",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return diffContainer(ctx.Args().Get(0), ctx.String("format"))
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information on containers or networks",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "format the output using the given Go template",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing container or network name")
+		}
+		return inspect(ctx.Args().Slice(), ctx.String("format"))
+	},
+}
+
+var waitCommand = cli.Command{
+	Name:  "wait",
+	Usage: "block until one or more containers stop, then print their exit codes",
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return waitContainers(ctx.Args().Slice())
+	},
+}
+
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach local standard input, output and error to a running container, detach with ctrl-p ctrl-q",
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		exitCode, err := attachContainer(ctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return cli.Exit("", exitCode)
+		}
 		return nil
 	},
 }
@@ -181,6 +546,94 @@ var removeCommand = cli.Command{
 	},
 }
 
+var imagesCommand = cli.Command{
+	Name:  "images",
+	Usage: "list images",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			Usage:   "only show image IDs",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return listImages(ctx.Bool("quiet"))
+	},
+}
+
+var tagCommand = cli.Command{
+	Name:      "tag",
+	Usage:     "create a tag TARGET_IMAGE that refers to SOURCE_IMAGE",
+	ArgsUsage: "SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]",
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() != 2 {
+			return fmt.Errorf("tag requires exactly 2 arguments")
+		}
+		return tagImage(ctx.Args().Get(0), ctx.Args().Get(1))
+	},
+}
+
+var removeImageCommand = cli.Command{
+	Name:      "rmi",
+	Usage:     "remove one or more images",
+	ArgsUsage: "IMAGE [IMAGE...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "force",
+			Aliases: []string{"f"},
+			Usage:   "force removal of the image",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return removeImages(ctx.Args().Slice(), ctx.Bool("force"))
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from a docker save or OCI image-layout tar archive",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "input",
+			Aliases: []string{"i"},
+			Usage:   "read from tar archive file, instead of STDIN",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return loadImages(ctx.String("input"))
+	},
+}
+
+var saveCommand = cli.Command{
+	Name:      "save",
+	Usage:     "save images to a tar archive loadable by docker load and OCI tools",
+	ArgsUsage: "IMAGE [IMAGE...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "write to a file, instead of STDOUT",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return saveImages(ctx.Args().Slice(), ctx.String("output"))
+	},
+}
+
+var infoCommand = cli.Command{
+	Name:  "info",
+	Usage: "Display system-wide information",
+	Action: func(ctx *cli.Context) error {
+		return showInfo(ctx.String("storage-driver"))
+	},
+}
+
 var networkCommand = cli.Command{
 	Name:  "network",
 	Usage: "container network commands",
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"mydocker/cgroups"
+	"mydocker/container"
+	"mydocker/image"
+	"mydocker/network"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// 重启的退避时间从 initialRestartDelay 开始, 每次翻倍, 最长 maxRestartDelay
+	initialRestartDelay = 100 * time.Millisecond
+	maxRestartDelay     = time.Minute
+	// 容器运行超过 restartResetTime 后认为已经正常启动, 退避时间重新计算
+	restartResetTime = 10 * time.Second
+	// 容器没能启动时的退出码, 和 docker run 保持一致
+	startFailedExitCode = 125
+)
+
+// 启动一个脱离当前会话的 monitor 进程, 由它来启动容器并按重启策略重启容器
+func startMonitorProcess(containerId string) error {
+	cmd := exec.Command("/proc/self/exe", "monitor", containerId)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// 启动容器并等待它退出, 根据重启策略决定是否重新启动, 返回容器最后一次的退出码
+func monitorContainer(containerId string) int {
+	info, err := modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+		info.MonitorPid = os.Getpid()
+	})
+	if err != nil {
+		logrus.Errorf("Get container %s info error %v", containerId, err)
+		return startFailedExitCode
+	}
+
+	// 前台 -ti 容器使用伪终端, 后台容器的输入输出写入日志并支持 attach
+	var stdio containerStdio
+	if info.TTY {
+		stdio = newTTYSession()
+	} else if stdio, err = newContainerStreams(info); err != nil {
+		logrus.Errorf("Create container %s streams error %v", containerId, err)
+		return startFailedExitCode
+	}
+	defer stdio.Close()
+
+	delay := initialRestartDelay
+	exitCode := startFailedExitCode
+	for {
+		startTime := time.Now()
+		exitCode, err = startContainerProcess(info, stdio)
+		if err != nil {
+			logrus.Errorf("Start container %s error %v", containerId, err)
+			_, _ = modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+				info.State.Error = err.Error()
+			})
+		}
+
+		// 容器运行期间 mydocker stop 可能修改了容器信息, 需要重新读取
+		if info, err = getContainerInfoById(containerId); err != nil {
+			logrus.Errorf("Get container %s info error %v", containerId, err)
+			return exitCode
+		}
+		if !info.RestartPolicy.ShouldRestart(exitCode, info.RestartCount, info.ManuallyStopped) {
+			break
+		}
+
+		if time.Since(startTime) > restartResetTime {
+			delay = initialRestartDelay
+		}
+		_, _ = modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+			info.Status = container.RESTARTING
+			info.Pid = " "
+		})
+		logrus.Infof("Restart container %s in %v", containerId, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+
+		// 等待期间容器可能被停止
+		info, err = modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+			if !info.ManuallyStopped {
+				info.RestartCount++
+			}
+		})
+		if err != nil {
+			logrus.Errorf("Get container %s info error %v", containerId, err)
+			return exitCode
+		}
+		if info.ManuallyStopped {
+			break
+		}
+	}
+
+	_, _ = modifyContainerInfo(containerId, func(info *container.ContainerInfo) {
+		if info.ManuallyStopped {
+			info.Status = container.STOP
+		} else {
+			info.Status = container.Exit
+		}
+		info.Pid = " "
+	})
+	return exitCode
+}
+
+// 容器进程的标准输入输出, 在容器多次重启之间保持不变
+// 后台容器由 containerStreams 接管, 前台 -ti 容器使用 ttySession
+type containerStdio interface {
+	// 在 cmd.Start 之前配置 cmd 的标准输入输出, 返回的函数关闭父进程中属于子进程的那一端
+	attachProcess(cmd *exec.Cmd) (func(), error)
+	// 容器进程退出后调用, 等待输出写完
+	detachProcess()
+	Close()
+}
+
+// 启动一次容器进程, 配置 cgroup 和网络, 并等待容器进程退出
+func startContainerProcess(info *container.ContainerInfo, stdio containerStdio) (int, error) {
+	// 旧版本记录的 Env 只有 -e 的值, 没有 PATH 时容器内找不到命令
+	env := container.MergeEnv([]string{image.DefaultPathEnv}, info.Env)
+	parent, writePipe, err := container.NewParentProcess(info.Id, info.StorageDriver, info.Volume, info.ImageRef(), env)
+	if err != nil {
+		return startFailedExitCode, err
+	}
+	closeChildEnds, err := stdio.attachProcess(parent)
+	if err != nil {
+		return startFailedExitCode, err
+	}
+	// 容器退出后输出全部写完才断开 attach 的客户端, 这时退出码已经记录好了
+	defer stdio.detachProcess()
+	defer closeChildEnds()
+
+	// Start 开始进入子进程
+	if err := parent.Start(); err != nil {
+		return startFailedExitCode, err
+	}
+	pid := strconv.Itoa(parent.Process.Pid)
+	_, err = modifyContainerInfo(info.Id, func(info *container.ContainerInfo) {
+		info.Pid = pid
+		info.Status = container.RUNNING
+		info.State = container.State{StartedAt: time.Now()}
+		if info.Healthcheck != nil {
+			info.Health = &container.Health{Status: container.HealthStarting}
+		}
+	})
+	if err != nil {
+		logrus.Errorf("Record container info error %v", err)
+	}
+
+	// 每个容器使用独立的 cgroup, 容器退出后释放
+	cgroupManager := cgroups.NewCgroupManager(containerCgroupPath(info.Id))
+	defer cgroupManager.Destroy()
+	cgroupManager.Set(info.ResourceConfig)
+	cgroupManager.Apply(parent.Process.Pid)
+
+	if info.Network != "" {
+		network.Init()
+		info.Pid = pid
+		if err := network.Connect(info.Network, info); err != nil {
+			// 子进程还阻塞在读取管道上, 需要杀掉
+			_ = parent.Process.Kill()
+			_ = parent.Wait()
+			return startFailedExitCode, fmt.Errorf("connect network %s: %v", info.Network, err)
+		}
+		defer func() {
+			if err := network.Disconnect(info.Network, info); err != nil {
+				logrus.Errorf("Disconnect network %s error %v", info.Network, err)
+			}
+		}()
+		networkSettings := info.NetworkSettings
+		_, _ = modifyContainerInfo(info.Id, func(info *container.ContainerInfo) {
+			info.NetworkSettings = networkSettings
+		})
+	}
+
+	sendInitCommand(info, writePipe)
+
+	if info.Healthcheck != nil {
+		stopHealthcheck := make(chan struct{})
+		defer close(stopHealthcheck)
+		go runHealthcheck(info.Id, pid, info.Healthcheck, stopHealthcheck)
+	}
+	exitCode := waitContainerProcess(parent)
+
+	// cgroup 销毁前读取 OOM 信息
+	oomKilled := cgroupManager.OOMKilled()
+	_, _ = modifyContainerInfo(info.Id, func(info *container.ContainerInfo) {
+		info.State.ExitCode = exitCode
+		info.State.FinishedAt = time.Now()
+		info.State.OOMKilled = oomKilled
+	})
+	return exitCode, nil
+}
+
+// 等待容器进程退出, 被信号杀死时退出码为 128+信号值
+func waitContainerProcess(cmd *exec.Cmd) int {
+	_ = cmd.Wait()
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// 宿主机重启后, 恢复重启策略为 always 或 unless-stopped 的容器
+func recoverContainers() {
+	for _, info := range getAllContainerInfos() {
+		if info.Status != container.RUNNING && info.Status != container.RESTARTING && !info.RestartPolicy.ShouldRecover(info.ManuallyStopped) {
+			continue
+		}
+		// monitor 进程还在, 容器由它负责
+		if info.MonitorPid > 0 && processExists(info.MonitorPid) {
+			continue
+		}
+		if !info.RestartPolicy.ShouldRecover(info.ManuallyStopped) {
+			// 没有重启策略的容器已经随宿主机重启退出了
+			_, _ = modifyContainerInfo(info.Id, func(info *container.ContainerInfo) {
+				info.Status = container.Exit
+				info.Pid = " "
+			})
+			continue
+		}
+		logrus.Infof("Recover container %s", info.Name)
+		_, err := modifyContainerInfo(info.Id, func(info *container.ContainerInfo) {
+			info.ManuallyStopped = false
+		})
+		if err != nil {
+			continue
+		}
+		if err := startMonitorProcess(info.Id); err != nil {
+			logrus.Errorf("Start monitor process for %s error %v", info.Name, err)
+		}
+	}
+}
+
+func processExists(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+// 容器在各个 cgroup hierarchy 中的相对路径
+func containerCgroupPath(containerId string) string {
+	return "mydocker-" + containerId
+}
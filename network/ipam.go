@@ -1,7 +1,7 @@
 package network
 
 import (
-	"encoding/json"
+	"mydocker/store"
 	"net"
 	"os"
 	"path"
@@ -31,19 +31,7 @@ func (ipam *IPAM) load() error {
 			return err
 		}
 	}
-	subnetConfigFile, err := os.Open(ipam.SubnetAllocatorPath)
-	defer subnetConfigFile.Close()
-	if err != nil {
-		return err
-	}
-
-	subnetJson := make([]byte, 2000)
-	n, err := subnetConfigFile.Read(subnetJson)
-	if err != nil {
-		return err
-	}
-
-	err = json.Unmarshal(subnetJson[:n], ipam.Subnets)
+	err := store.ReadJSON(ipam.SubnetAllocatorPath, ipam.Subnets)
 	if err != nil {
 		logrus.Errorf("Error dump allocation info, %v", err)
 		return err
@@ -53,34 +41,25 @@ func (ipam *IPAM) load() error {
 }
 
 func (ipam *IPAM) dump() error {
-	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
-	if _, err := os.Stat(ipamConfigFileDir); err != nil {
-		if os.IsNotExist(err) {
-			os.MkdirAll(ipamConfigFileDir, 0644)
-		} else {
-			return err
-		}
-	}
-	subnetConfigFile, err := os.OpenFile(ipam.SubnetAllocatorPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
-	defer subnetConfigFile.Close()
-	if err != nil {
-		return err
-	}
+	return store.WriteJSON(ipam.SubnetAllocatorPath, ipam.Subnets)
+}
 
-	ipamConfigJson, err := json.Marshal(ipam.Subnets)
-	if err != nil {
-		return err
+// 锁住分配文件, 保证 load 到 dump 之间不会有其他 mydocker 进程修改
+func (ipam *IPAM) lock() (*store.FileLock, error) {
+	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
+	if err := os.MkdirAll(ipamConfigFileDir, 0644); err != nil {
+		return nil, err
 	}
+	return store.Lock(ipam.SubnetAllocatorPath + ".lock")
+}
 
-	_, err = subnetConfigFile.Write(ipamConfigJson)
+func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
+	lock, err := ipam.lock()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer lock.Unlock()
 
-	return nil
-}
-
-func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
 	// 存放网段中地址分配信息的数组
 	ipam.Subnets = &map[string]string{}
 
@@ -112,16 +91,22 @@ func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
 		}
 	}
 
-	ipam.dump()
+	err = ipam.dump()
 	return
 }
 
 func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
+	lock, err := ipam.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	ipam.Subnets = &map[string]string{}
 
 	_, subnet, _ = net.ParseCIDR(subnet.String())
 
-	err := ipam.load()
+	err = ipam.load()
 	if err != nil {
 		logrus.Errorf("Error dump allocation info, %v", err)
 	}
@@ -137,6 +122,5 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	ipalloc[c] = '0'
 	(*ipam.Subnets)[subnet.String()] = string(ipalloc)
 
-	ipam.dump()
-	return nil
+	return ipam.dump()
 }
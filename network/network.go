@@ -1,9 +1,9 @@
 package network
 
 import (
-	"encoding/json"
 	"fmt"
 	"mydocker/container"
+	"mydocker/store"
 	"net"
 	"os"
 	"os/exec"
@@ -20,6 +20,7 @@ import (
 
 var (
 	defaultNetworkPath = "/var/run/mydocker/network/network/"
+	networkLockFile    = defaultNetworkPath + ".lock"
 	drivers            = map[string]NetworkDriver{}
 	networks           = map[string]*Network{}
 )
@@ -57,20 +58,7 @@ func (nw *Network) dump(dumpPath string) error {
 	}
 
 	nwPath := path.Join(dumpPath, nw.Name)
-	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		logrus.Errorf("error: %v", err)
-		return err
-	}
-	defer nwFile.Close()
-	nwJson, err := json.Marshal(nw)
-	if err != nil {
-		logrus.Errorf("error: %v", err)
-		return err
-	}
-
-	_, err = nwFile.Write(nwJson)
-	if err != nil {
+	if err := store.WriteJSON(nwPath, nw); err != nil {
 		logrus.Errorf("error: %v", err)
 		return err
 	}
@@ -90,18 +78,7 @@ func (nw *Network) remove(dumpPath string) error {
 }
 
 func (nw *Network) load(dumpPath string) error {
-	nwConfigFile, err := os.Open(dumpPath)
-	defer nwConfigFile.Close()
-	if err != nil {
-		return err
-	}
-	nwJson := make([]byte, 2000)
-	n, err := nwConfigFile.Read(nwJson)
-	if err != nil {
-		return err
-	}
-
-	err = json.Unmarshal(nwJson[:n], nw)
+	err := store.ReadJSON(dumpPath, nw)
 	if err != nil {
 		logrus.Errorf("Error load nw info: %v", err)
 		return err
@@ -129,6 +106,10 @@ func Init() error {
 		if info.IsDir() {
 			return nil
 		}
+		// 跳过锁文件和写入中的临时文件
+		if strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
 
 		// 加载文件名作为网络名
 		_, nwName := path.Split(nwPath)
@@ -148,7 +129,23 @@ func Init() error {
 }
 
 func CreateNetwork(driver, subnet, name string) error {
-	_, cidr, _ := net.ParseCIDR(subnet)
+	// 持有网络锁, 防止同时创建同名的网络
+	lock, err := store.Lock(networkLockFile)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err := os.Stat(path.Join(defaultNetworkPath, name)); err == nil {
+		return fmt.Errorf("network with name %s already exists", name)
+	}
+	if _, ok := drivers[driver]; !ok {
+		return fmt.Errorf("No such network driver: %s", driver)
+	}
+
+	_, cidr, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
 	// 通过 IPAM 分配网关 IP, 获取到网段中第一个 IP 作为网关的 IP
 	gatawayIp, err := ipAllocator.Allocate(cidr)
 	if err != nil {
@@ -184,6 +181,12 @@ func ListNetwork() {
 }
 
 func DeleteNetwork(networkName string) error {
+	lock, err := store.Lock(networkLockFile)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	nw, ok := networks[networkName]
 	if !ok {
 		return fmt.Errorf("No Such Network: %s", networkName)
@@ -245,6 +248,7 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 	if err != nil {
 		return fmt.Errorf("fail config endpoint: %v", err)
 	}
+	ep.MacAddress = peerLink.Attrs().HardwareAddr
 
 	// 将容器的网络端点加入到容器的网络空间中
 	// 并使这个函数下面的操作都在这个网络空间中进行
@@ -295,14 +299,19 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 }
 
 func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
-	for _, pm := range ep.PortMapping {
+	return setPortMapping("-A", ep.IPAddress, ep.PortMapping)
+}
+
+// action 为 -A 时添加端口映射的 iptables 规则, 为 -D 时删除
+func setPortMapping(action string, ip net.IP, portMappings []string) error {
+	for _, pm := range portMappings {
 		portMapping := strings.Split(pm, ":")
 		if len(portMapping) != 2 {
 			logrus.Errorf("port mapping format error, %v", pm)
 			continue
 		}
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		iptablesCmd := fmt.Sprintf("-t nat "+action+" PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			portMapping[0], ip.String(), portMapping[1])
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		output, err := cmd.Output()
 		if err != nil {
@@ -344,5 +353,42 @@ func Connect(networkName string, cinfo *container.ContainerInfo) error {
 	}
 
 	// 配置端口映射信息, 例如 mydocker run -p 8080 : 80
-	return configPortMapping(ep, cinfo)
+	if err = configPortMapping(ep, cinfo); err != nil {
+		return err
+	}
+
+	// 记录端点信息, 供 mydocker inspect 查看
+	ones, _ := network.IpRange.Mask.Size()
+	cinfo.NetworkSettings = container.NetworkSettings{
+		Network:     networkName,
+		EndpointID:  ep.ID,
+		IPAddress:   ep.IPAddress.String(),
+		IPPrefixLen: ones,
+		Gateway:     network.IpRange.IP.String(),
+		MacAddress:  ep.MacAddress.String(),
+		Ports:       ep.PortMapping,
+	}
+	return nil
+}
+
+// 容器退出后释放它在网络中的 IP 并删除端口映射
+// veth 设备随着容器的 net namespace 一起销毁, 不需要单独删除
+func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("No such Network: %s", networkName)
+	}
+	ip := net.ParseIP(cinfo.NetworkSettings.IPAddress)
+	if ip == nil {
+		return nil
+	}
+	if err := setPortMapping("-D", ip, cinfo.NetworkSettings.Ports); err != nil {
+		return err
+	}
+	return ipAllocator.Release(network.IpRange, &ip)
+}
+
+func GetNetwork(networkName string) (*Network, bool) {
+	nw, ok := networks[networkName]
+	return nw, ok
 }
@@ -1,48 +1,175 @@
 package nsenter
 
 /*
+#define _GNU_SOURCE
 #include <errno.h>
+#include <fcntl.h>
 #include <sched.h>
+#include <signal.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
-#include <fcntl.h>
+#include <linux/capability.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+#include <sys/types.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+// 命令无法执行时的退出码, 和 shell 的约定一致
+#define EXIT_CANNOT_INVOKE 126
+
+// 把当前进程加入容器的 cgroup, paths 是用冒号分隔的 cgroup.procs 文件列表
+// 必须在进入 mnt namespace 之前做, 否则看不到宿主机上的 cgroup 文件系统
+static int join_cgroups(char *paths) {
+	char pid[32];
+	snprintf(pid, sizeof(pid), "%d", getpid());
+	for (char *path = strtok(paths, ":"); path != NULL; path = strtok(NULL, ":")) {
+		int fd = open(path, O_WRONLY);
+		if (fd == -1) {
+			fprintf(stderr, "mydocker exec: open %s failed: %s\n", path, strerror(errno));
+			return -1;
+		}
+		if (write(fd, pid, strlen(pid)) == -1) {
+			fprintf(stderr, "mydocker exec: join cgroup %s failed: %s\n", path, strerror(errno));
+			close(fd);
+			return -1;
+		}
+		close(fd);
+	}
+	return 0;
+}
+
+// 读取容器 init 进程的 capability bounding set, 必须在进入 mnt namespace 之前读取
+static int read_cap_bounding(const char *pid, unsigned long long *caps) {
+	char path[64];
+	snprintf(path, sizeof(path), "/proc/%s/status", pid);
+	FILE *f = fopen(path, "r");
+	if (!f) {
+		fprintf(stderr, "mydocker exec: open %s failed: %s\n", path, strerror(errno));
+		return -1;
+	}
+	char line[256];
+	int found = 0;
+	while (fgets(line, sizeof(line), f)) {
+		if (sscanf(line, "CapBnd: %llx", caps) == 1) {
+			found = 1;
+			break;
+		}
+	}
+	fclose(f);
+	if (!found) {
+		fprintf(stderr, "mydocker exec: CapBnd not found in %s\n", path);
+		return -1;
+	}
+	return 0;
+}
+
+// 从 bounding set 中去掉容器进程没有的 capability, root 执行新程序后得到的 capability 不会超过 bounding set
+// 这样 exec 的命令和容器进程的权限一致
+static int drop_capabilities(unsigned long long keep) {
+	for (int cap = 0; prctl(PR_CAPBSET_READ, cap, 0, 0, 0) >= 0; cap++) {
+		if (cap < 64 && (keep & (1ULL << cap))) {
+			continue;
+		}
+		if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) == -1) {
+			fprintf(stderr, "mydocker exec: drop capability %d failed: %s\n", cap, strerror(errno));
+			return -1;
+		}
+	}
+	return 0;
+}
+
+// 清空 inheritable capability, 否则 exec 的命令执行带有 file capability 的程序时可以拿回容器进程没有的权限
+// ambient capability 必须是 inheritable 的子集, 会一起被清空
+static int clear_inheritable(void) {
+	struct __user_cap_header_struct header = { _LINUX_CAPABILITY_VERSION_3, 0 };
+	struct __user_cap_data_struct data[2];
+	if (syscall(SYS_capget, &header, data) == -1) {
+		fprintf(stderr, "mydocker exec: capget failed: %s\n", strerror(errno));
+		return -1;
+	}
+	data[0].inheritable = 0;
+	data[1].inheritable = 0;
+	if (syscall(SYS_capset, &header, data) == -1) {
+		fprintf(stderr, "mydocker exec: capset failed: %s\n", strerror(errno));
+		return -1;
+	}
+	return 0;
+}
 
 __attribute__((constructor)) void enter_namespace(void) {
-	char *mydocker_pid;
-	mydocker_pid = getenv("mydocker_pid");
-	if (mydocker_pid) {
-		//fprintf(stdout, "got mydocker_pid=%s\n", mydocker_pid);
-	} else {
-		//fprintf(stdout, "missing mydocker_pid env skip nsenter");
+	char *mydocker_pid = getenv("mydocker_pid");
+	if (!mydocker_pid) {
 		return;
 	}
-	char *mydocker_cmd;
-	mydocker_cmd = getenv("mydocker_cmd");
-	if (mydocker_cmd) {
-		//fprintf(stdout, "got mydocker_cmd=%s\n", mydocker_cmd);
-	} else {
-		//fprintf(stdout, "missing mydocker_cmd env skip nsenter");
-		return;
+	char *mydocker_cgroup = getenv("mydocker_cgroup");
+	if (mydocker_cgroup && *mydocker_cgroup) {
+		if (join_cgroups(mydocker_cgroup) == -1) {
+			exit(EXIT_CANNOT_INVOKE);
+		}
 	}
-	int i;
-	char nspath[1024];
+
+	int privileged = getenv("mydocker_privileged") != NULL;
+	unsigned long long caps = 0;
+	if (!privileged && read_cap_bounding(mydocker_pid, &caps) == -1) {
+		exit(EXIT_CANNOT_INVOKE);
+	}
+
+	// 先打开所有的 namespace 文件, 进入 mnt namespace 之后就看不到宿主机的 /proc 了
 	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
+	int fds[5];
+	char nspath[1024];
+	for (int i = 0; i < 5; i++) {
+		snprintf(nspath, sizeof(nspath), "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
+		fds[i] = open(nspath, O_RDONLY);
+		if (fds[i] == -1) {
+			fprintf(stderr, "mydocker exec: open %s failed: %s\n", nspath, strerror(errno));
+			exit(EXIT_CANNOT_INVOKE);
+		}
+	}
+	for (int i = 0; i < 5; i++) {
+		if (setns(fds[i], 0) == -1) {
+			fprintf(stderr, "mydocker exec: setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
+			exit(EXIT_CANNOT_INVOKE);
+		}
+		close(fds[i]);
+	}
+	if (chdir("/") == -1) {
+		fprintf(stderr, "mydocker exec: chdir to / failed: %s\n", strerror(errno));
+		exit(EXIT_CANNOT_INVOKE);
+	}
 
-	for (i=0; i<5; i++) {
-		sprintf(nspath, "/proc/%s/ns/%s", mydocker_pid, namespaces[i]);
-		int fd = open(nspath, O_RDONLY);
+	// setns 进入 pid namespace 只对之后创建的子进程生效, 需要 fork 一次
+	pid_t child = fork();
+	if (child == -1) {
+		fprintf(stderr, "mydocker exec: fork failed: %s\n", strerror(errno));
+		exit(EXIT_CANNOT_INVOKE);
+	}
+	if (child == 0) {
+		// --privileged 时保留 mydocker 自己的全部 capability, 不受容器进程的限制
+		if (!privileged && (drop_capabilities(caps) == -1 || clear_inheritable() == -1)) {
+			exit(EXIT_CANNOT_INVOKE);
+		}
+		// 回到 Go 的 main, 按容器内的 /etc/passwd 切换用户和工作目录后执行命令
+		// Go 从进程的初始栈上读取环境变量, 这里用 unsetenv 清理不会生效, 由 Go 清理
+		return;
+	}
 
-		if (setns(fd, 0) == -1) {
-			//fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
-		} else {
-			//fprintf(stdout, "setns on %s namespace succeeded\n", namespaces[i]);
+	// 终端产生的信号由前台进程组里的子进程自己处理
+	signal(SIGINT, SIG_IGN);
+	signal(SIGQUIT, SIG_IGN);
+	int status;
+	while (waitpid(child, &status, 0) == -1) {
+		if (errno != EINTR) {
+			exit(EXIT_CANNOT_INVOKE);
 		}
-		close(fd);
 	}
-	int res = system(mydocker_cmd);
-	exit(0);
-	return;
+	// 把命令的退出码返回给调用者
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 */
 import "C"
@@ -3,119 +3,155 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"math/rand"
-	"mydocker/cgroups"
-	"mydocker/cgroups/subsystems"
 	"mydocker/container"
-	"mydocker/network"
+	"mydocker/image"
+	"mydocker/store"
+	"net"
 	"os"
-	"strconv"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-func Run(tty bool, comArray []string, res *subsystems.ResourceConfig, containerName, volume, imageName string, envSlice []string, nw string, portmapping []string) {
-	containerID := randStringBytes(10)
-	if containerName == "" {
-		containerName = containerID
+// 前台运行时返回容器的退出码, 后台运行时返回 0
+func Run(info *container.ContainerInfo) (int, error) {
+	containerID, err := container.NewContainerID()
+	if err != nil {
+		return 0, fmt.Errorf("generate container id error %v", err)
 	}
-
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume, imageName, envSlice)
-	if parent == nil {
-		logrus.Errorf("New parent process error")
-		return
+	info.Id = containerID
+	if info.Name == "" {
+		info.Name = container.ShortID(containerID)
 	}
-	// Start 开始进入子进程
-	if err := parent.Start(); err != nil {
-		logrus.Error(err)
+	info.Command = strings.Join(info.CmdArray, " ")
+	info.Mounts = container.ParseVolumeMounts(info.Volume)
+	info.CreatedTime = time.Now().Format("2006-01-02 15:04:05")
+	info.Status = container.RUNNING
+
+	// 记录容器时持有镜像存储的锁, mydocker rmi 不会删除刚开始使用的镜像
+	if err := image.Use(info.ImageID, func() error { return recordContainerInfo(info) }); err != nil {
+		return 0, err
 	}
 
-	containerName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName)
-	if err != nil {
-		logrus.Errorf("Record container info error %v", err)
-		return
+	// 后台运行的容器交给独立的 monitor 进程管理, 这样 mydocker run 退出后容器依然可以被重启
+	if !info.TTY {
+		if err := startMonitorProcess(info.Id); err != nil {
+			return 0, fmt.Errorf("start monitor process error %v", err)
+		}
+		// 输出容器 ID, 方便脚本配合 mydocker wait 等命令使用
+		fmt.Fprintln(os.Stdout, info.Id)
+		return 0, nil
 	}
 
-	cgroupManager := cgroups.NewCgroupManager("mydocker-cgroup")
-	defer cgroupManager.Destroy()
-	cgroupManager.Set(res)
-	cgroupManager.Apply(parent.Process.Pid)
+	exitCode := monitorContainer(info.Id)
+	// 卸载失败时保留容器记录, 可以用 mydocker rm 再次清理
+	if err := container.DeleteWorkSpace(info.StorageDriver, info.Volume, info.Id); err != nil {
+		logrus.Errorf("%v", err)
+		return exitCode, nil
+	}
+	deleteContainerInfo(info.Id)
+	return exitCode, nil
+}
 
-	if nw != "" {
-		network.Init()
-		containerInfo := &container.ContainerInfo{
-			Id:          containerID,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: portmapping,
-		}
-		if err := network.Connect(nw, containerInfo); err != nil {
-			logrus.Errorf("Error Connect Network %v", err)
-			return
+// -P 时把镜像声明的 tcp 端口映射到宿主机上随机的空闲端口, 已经用 -p 映射的端口不再映射
+func publishPorts(exposedPorts, portMapping []string) ([]string, error) {
+	mapped := map[string]bool{}
+	for _, pm := range portMapping {
+		if parts := strings.Split(pm, ":"); len(parts) == 2 {
+			mapped[parts[1]] = true
 		}
 	}
-
-	sendInitCommand(comArray, writePipe)
-	if tty {
-		parent.Wait()
-		deleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume, containerName)
+	result := append([]string(nil), portMapping...)
+	for _, exposed := range exposedPorts {
+		port, proto, _ := strings.Cut(exposed, "/")
+		// 端口映射只支持 tcp
+		if (proto != "" && proto != "tcp") || mapped[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return nil, fmt.Errorf("allocate host port for %s error %v", exposed, err)
+		}
+		hostPort := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		result = append(result, fmt.Sprintf("%d:%s", hostPort, port))
+		mapped[port] = true
 	}
+	return result, nil
 }
 
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	logrus.Infof("command all is %s", command)
+func sendInitCommand(info *container.ContainerInfo, writePipe *os.File) {
+	logrus.Infof("command all is %s", strings.Join(info.CmdArray, " "))
+	config, _ := json.Marshal(container.InitConfig{
+		Args:       info.CmdArray,
+		WorkingDir: info.WorkingDir,
+		User:       info.User,
+	})
 	// 供后面子进程读取
-	_, _ = writePipe.WriteString(command)
+	_, _ = writePipe.Write(config)
 	writePipe.Close()
 }
 
-func recordContainerInfo(containerPID int, commandArray []string, containerName string) (string, error) {
-	id := randStringBytes(10)
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArray, "")
-	if containerName == "" {
-		containerName = id
+// 创建容器的状态目录, 创建期间持有全局锁, 保证容器名不会重复
+func recordContainerInfo(containerInfo *container.ContainerInfo) error {
+	containersDir := path.Clean(fmt.Sprintf(container.DefaultInfoLocation, ""))
+	if err := os.MkdirAll(containersDir, 0755); err != nil {
+		return err
 	}
-	containerInfo := &container.ContainerInfo{
-		Id:          id,
-		Pid:         strconv.Itoa(containerPID),
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      container.RUNNING,
-		Name:        containerName,
+	lock, err := store.Lock(container.ContainersLockFile)
+	if err != nil {
+		return fmt.Errorf("lock %s error %v", container.ContainersLockFile, err)
 	}
+	defer lock.Unlock()
 
-	jsonBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		logrus.Errorf("Record container info error %v", err)
-		return "", err
+	for _, info := range getAllContainerInfos() {
+		if info.Name == containerInfo.Name {
+			return fmt.Errorf("Conflict. The container name %q is already in use by container %q. You have to remove that container to be able to reuse that name", containerInfo.Name, info.Id)
+		}
 	}
-	jsonStr := string(jsonBytes)
 
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	if err := os.MkdirAll(dirUrl, 0622); err != nil {
+	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
+	if err := os.Mkdir(dirUrl, 0622); err != nil {
 		logrus.Errorf("Mkdir error %s error %v", dirUrl, err)
-		return "", err
+		return err
 	}
+	return updateContainerInfo(containerInfo)
+}
 
-	fileName := dirUrl + "/" + container.ConfigName
-	file, err := os.Create(fileName)
-	defer file.Close()
-	if err != nil {
-		logrus.Errorf("Create file %s error %v", fileName, err)
-		return "", err
+// 将 containerInfo 原子地写回到容器的 config.json
+// 需要基于已有内容修改时使用 modifyContainerInfo
+func updateContainerInfo(containerInfo *container.ContainerInfo) error {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
+	configFilePath := dirURL + container.ConfigName
+	if err := store.WriteJSON(configFilePath, containerInfo); err != nil {
+		logrus.Errorf("Write file %s error: %v", configFilePath, err)
+		return err
 	}
+	return nil
+}
 
-	if _, err := file.WriteString(jsonStr); err != nil {
-		logrus.Errorf("File write string error %v", err)
-		return "", err
-	}
+// 锁住容器的状态, 调用者负责 Unlock
+func lockContainer(containerId string) (*store.FileLock, error) {
+	lockPath := fmt.Sprintf(container.DefaultInfoLocation, containerId) + container.ConfigLockName
+	return store.Lock(lockPath)
+}
 
-	return containerName, nil
+// 在容器锁的保护下读取最新的容器信息, 调用 modify 修改后写回
+// monitor, 健康检查, stop 等可能同时修改同一个容器, 都需要通过这里修改
+func modifyContainerInfo(containerId string, modify func(*container.ContainerInfo)) (*container.ContainerInfo, error) {
+	lock, err := lockContainer(containerId)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+	info, err := getContainerInfoById(containerId)
+	if err != nil {
+		return nil, err
+	}
+	modify(info)
+	return info, updateContainerInfo(info)
 }
 
 func deleteContainerInfo(containerId string) {
@@ -124,13 +160,3 @@ func deleteContainerInfo(containerId string) {
 		logrus.Errorf("Remove dir %s error %v", dirURL, err)
 	}
 }
-
-func randStringBytes(n int) string {
-	letterBytes := "1234567890"
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
-}
@@ -6,49 +6,69 @@ import (
 	"mydocker/container"
 	"os"
 	"strconv"
+	"strings"
 	"syscall"
 
-	"io/fs"
-
 	"github.com/sirupsen/logrus"
 )
 
 func stopContainer(containerName string) {
-	pid, err := GetContainerPidByName(containerName)
+	containerInfo, err := resolveContainer(containerName)
 	if err != nil {
-		logrus.Errorf("Get contaienr pid by name %s error %v", containerName, err)
+		logrus.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	pidInt, err := strconv.Atoi(pid)
+	// 先标记为手动停止, monitor 进程看到后就不会再重启容器
+	// 容器的状态和 pid 由 monitor 进程在容器退出后更新, 信号发送失败时容器依然在运行
+	var pid string
+	_, err = modifyContainerInfo(containerInfo.Id, func(info *container.ContainerInfo) {
+		pid = strings.TrimSpace(info.Pid)
+		info.ManuallyStopped = true
+		// 容器正在等待重启时没有进程, 直接标记为停止
+		if pid == "" {
+			info.Status = container.STOP
+			info.Pid = " "
+		}
+	})
 	if err != nil {
-		logrus.Errorf("Conver pid from string to int error %v", err)
+		logrus.Errorf("Update container %s info error %v", containerName, err)
 		return
 	}
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
-		logrus.Errorf("Stop container %s error %v", containerName, err)
+	if pid == "" {
 		return
 	}
-	containerInfo, err := getContainerInfoByName(containerName)
+	pidInt, err := strconv.Atoi(pid)
 	if err != nil {
-		logrus.Errorf("Get container %s info error %v", containerName, err)
+		logrus.Errorf("Conver pid from string to int error %v", err)
 		return
 	}
-	containerInfo.Status = container.STOP
-	containerInfo.Pid = " "
-	newContentBytes, err := json.Marshal(containerInfo)
+	// 镜像可以通过 StopSignal 指定其他信号, 例如 nginx 使用 SIGQUIT
+	sig, err := container.ParseSignal(containerInfo.StopSignal)
 	if err != nil {
-		logrus.Errorf("Json marshal %s error %v", containerName, err)
+		logrus.Warnf("Container %s has invalid stop signal, use SIGTERM: %v", containerName, err)
+		sig = syscall.SIGTERM
+	}
+	err = syscall.Kill(pidInt, sig)
+	if err == syscall.ESRCH {
+		// 进程已经不存在了, monitor 进程可能也已经退出, 没有人会再更新状态
+		_, _ = modifyContainerInfo(containerInfo.Id, func(info *container.ContainerInfo) {
+			info.Status = container.STOP
+			info.Pid = " "
+		})
 		return
 	}
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	configFilePath := dirURL + container.ConfigName
-	if err := os.WriteFile(configFilePath, newContentBytes, fs.FileMode(0622)); err != nil {
-		logrus.Errorf("Write file %s error: %v", configFilePath, err)
+	if err != nil {
+		logrus.Errorf("Stop container %s error %v", containerName, err)
+		// 容器没有停止, 撤销手动停止的标记, 重启策略继续生效
+		_, _ = modifyContainerInfo(containerInfo.Id, func(info *container.ContainerInfo) {
+			info.ManuallyStopped = false
+		})
+		return
 	}
 }
 
-func getContainerInfoByName(containerName string) (*container.ContainerInfo, error) {
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+func getContainerInfoById(containerId string) (*container.ContainerInfo, error) {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerId)
 	configFilePath := dirURL + container.ConfigName
 	contentBytes, err := os.ReadFile(configFilePath)
 	if err != nil {
@@ -57,23 +77,40 @@ func getContainerInfoByName(containerName string) (*container.ContainerInfo, err
 	}
 	var containerInfo container.ContainerInfo
 	if err := json.Unmarshal(contentBytes, &containerInfo); err != nil {
-		logrus.Errorf("GetContainerInfoByName unmarshal error %v", err)
+		logrus.Errorf("GetContainerInfoById unmarshal error %v", err)
 		return nil, err
 	}
 	return &containerInfo, nil
 }
 
 func removeContainer(containerName string) {
-	containerInfo, err := getContainerInfoByName(containerName)
+	containerInfo, err := resolveContainer(containerName)
+	if err != nil {
+		logrus.Errorf("Get container %s info error %v", containerName, err)
+		return
+	}
+	// 持有容器锁, 防止删除的同时 monitor 还在修改容器状态
+	lock, err := lockContainer(containerInfo.Id)
 	if err != nil {
+		logrus.Errorf("Lock container %s error %v", containerName, err)
+		return
+	}
+	defer lock.Unlock()
+	if containerInfo, err = getContainerInfoById(containerInfo.Id); err != nil {
 		logrus.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
-	if containerInfo.Status != container.STOP {
+	if containerInfo.Status == container.RUNNING || containerInfo.Status == container.RESTARTING {
 		logrus.Errorf("Couldn't remove running container")
 		return
 	}
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	// 后台容器退出后挂载点依然保留, 删除容器时才清理
+	// 卸载失败时保留容器记录, 可以稍后再次删除
+	if err := container.DeleteWorkSpace(containerInfo.StorageDriver, containerInfo.Volume, containerInfo.Id); err != nil {
+		logrus.Errorf("Remove container %s error %v", containerName, err)
+		return
+	}
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Id)
 	if err := os.RemoveAll(dirURL); err != nil {
 		logrus.Errorf("Remove file %s error %v", dirURL, err)
 		return
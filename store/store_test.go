@@ -0,0 +1,51 @@
+package store
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLockedReadModifyWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "counter.json")
+	lockPath := filepath.Join(dir, "counter.lock")
+	if err := WriteJSON(path, 0); err != nil {
+		t.Fatalf("write json error %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 每次都重新打开锁文件, 和不同进程的行为一致
+			lock, err := Lock(lockPath)
+			if err != nil {
+				t.Errorf("lock error %v", err)
+				return
+			}
+			defer lock.Unlock()
+			var n int
+			if err := ReadJSON(path, &n); err != nil {
+				t.Errorf("read json error %v", err)
+				return
+			}
+			if err := WriteJSON(path, n+1); err != nil {
+				t.Errorf("write json error %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int
+	if err := ReadJSON(path, &n); err != nil {
+		t.Fatalf("read json error %v", err)
+	}
+	if n != 20 {
+		t.Fatalf("expect 20, got %d", n)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, ".*.tmp*")); len(matches) != 0 {
+		t.Fatalf("temp files left: %v", matches)
+	}
+}
@@ -0,0 +1,36 @@
+package term
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// 打开一对伪终端, master 留在宿主机一侧, slave 作为容器进程的控制终端
+func OpenPty() (master, slave *os.File, err error) {
+	masterFd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	master = os.NewFile(uintptr(masterFd), "/dev/ptmx")
+
+	// unlockpt
+	if err := unix.IoctlSetPointerInt(masterFd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlockpt: %v", err)
+	}
+	// ptsname
+	ptyNumber, err := unix.IoctlGetInt(masterFd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("ptsname: %v", err)
+	}
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptyNumber)
+	slave, err = os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,75 @@
+package term
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// 终端原来的设置, 用于恢复
+type State struct {
+	termios unix.Termios
+}
+
+func IsTerminal(fd uintptr) bool {
+	_, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	return err == nil
+}
+
+func GetState(fd uintptr) (*State, error) {
+	termios, err := unix.IoctlGetTermios(int(fd), unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	return &State{termios: *termios}, nil
+}
+
+func Restore(fd uintptr, state *State) error {
+	return unix.IoctlSetTermios(int(fd), unix.TCSETS, &state.termios)
+}
+
+// 关闭行缓冲和 XON/XOFF 流控, 输入的每个字节立即可读
+// 这样 attach 才能及时识别 ctrl-p ctrl-q, 回显和信号处理保持不变
+func SetInputUnbuffered(fd uintptr) (*State, error) {
+	oldState, err := GetState(fd)
+	if err != nil {
+		return nil, err
+	}
+	termios := oldState.termios
+	termios.Lflag &^= unix.ICANON
+	termios.Iflag &^= unix.IXON
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, &termios); err != nil {
+		return nil, err
+	}
+	return oldState, nil
+}
+
+// 把终端设置为 raw 模式, 输入不做行缓冲和回显, 信号键作为普通字节传给容器
+// 和 cfmakeraw(3) 的效果一致
+func MakeRaw(fd uintptr) (*State, error) {
+	oldState, err := GetState(fd)
+	if err != nil {
+		return nil, err
+	}
+	termios := oldState.termios
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(int(fd), unix.TCSETS, &termios); err != nil {
+		return nil, err
+	}
+	return oldState, nil
+}
+
+func GetWinsize(fd uintptr) (*unix.Winsize, error) {
+	return unix.IoctlGetWinsize(int(fd), unix.TIOCGWINSZ)
+}
+
+// 修改终端窗口大小, 内核会给终端的前台进程组发送 SIGWINCH
+func SetWinsize(fd uintptr, ws *unix.Winsize) error {
+	return unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, ws)
+}
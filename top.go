@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"mydocker/cgroups"
+	"mydocker/container"
+	"os"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// /proc/<pid>/stat 中的时间以时钟滴答为单位, Linux 上固定为 100
+const clockTicks = 100
+
+// 容器内一个进程的信息, 全部从宿主机的 /proc 读取
+type processInfo struct {
+	Pid       int
+	NsPid     int // 容器 pid namespace 中的 PID
+	Ppid      int
+	User      string
+	CPUTime   time.Duration
+	CPU       float64 // 进程启动以来的平均 CPU 使用率
+	RSS       int64   // kB
+	Mem       float64
+	StartTime time.Duration // 相对系统启动的时间
+	Command   string
+}
+
+// 列出容器内的进程, 没有 psArgs 时直接读取 /proc
+// 有 psArgs 时和 docker 一样在宿主机上执行 ps, 再按 PID 列过滤出容器的进程
+func topContainer(containerName string, psArgs []string) error {
+	info, err := resolveContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if info.Status != container.RUNNING || strings.TrimSpace(info.Pid) == "" {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	pids, err := containerPids(info)
+	if err != nil {
+		return err
+	}
+	if len(psArgs) > 0 {
+		return runHostPs(pids, psArgs)
+	}
+
+	uptime, err := systemUptime()
+	if err != nil {
+		return err
+	}
+	memTotal := memTotalKB()
+	var processes []*processInfo
+	for _, pid := range pids {
+		p, err := readProcess(pid, uptime, memTotal)
+		if err != nil {
+			// 进程可能刚好退出了
+			continue
+		}
+		processes = append(processes, p)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprint(w, "USER\tPID\tCONTAINER PID\tPPID\t%CPU\t%MEM\tRSS\tTIME\tCOMMAND\n")
+	for _, p := range processes {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f\t%.1f\t%d\t%s\t%s\n",
+			p.User, p.Pid, p.NsPid, p.Ppid, p.CPU, p.Mem, p.RSS, formatCPUTime(p.CPUTime), p.Command)
+	}
+	return w.Flush()
+}
+
+// 容器的进程: 优先使用 cgroup 中的进程, 没有 cgroup 时按 pid namespace 查找
+func containerPids(info *container.ContainerInfo) ([]int, error) {
+	seen := map[int]bool{}
+	for _, procsFile := range cgroups.NewCgroupManager(containerCgroupPath(info.Id)).ProcsFiles() {
+		data, err := os.ReadFile(procsFile)
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Fields(string(data)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				seen[pid] = true
+			}
+		}
+	}
+	if len(seen) == 0 {
+		pidNs, err := os.Readlink(fmt.Sprintf("/proc/%s/ns/pid", strings.TrimSpace(info.Pid)))
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir("/proc")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			pid, err := strconv.Atoi(entry.Name())
+			if err != nil {
+				continue
+			}
+			if ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid)); err == nil && ns == pidNs {
+				seen[pid] = true
+			}
+		}
+	}
+	pids := make([]int, 0, len(seen))
+	for pid := range seen {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+func readProcess(pid int, uptime time.Duration, memTotal int64) (*processInfo, error) {
+	p := &processInfo{Pid: pid, NsPid: pid}
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		switch key {
+		case "NSpid":
+			// 从外到内每一层 pid namespace 中的 PID, 最后一个是容器内的
+			p.NsPid, _ = strconv.Atoi(fields[len(fields)-1])
+		case "PPid":
+			p.Ppid, _ = strconv.Atoi(fields[0])
+		case "Uid":
+			p.User = fields[0]
+			if u, err := user.LookupId(fields[0]); err == nil {
+				p.User = u.Username
+			}
+		case "VmRSS":
+			p.RSS, _ = strconv.ParseInt(fields[0], 10, 64)
+		}
+	}
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// comm 中可能有空格和括号, 从最后一个右括号之后开始解析
+	statStr := string(stat)
+	comm := ""
+	if start, end := strings.IndexByte(statStr, '('), strings.LastIndexByte(statStr, ')'); start >= 0 && end > start {
+		comm = statStr[start+1 : end]
+		statStr = statStr[end+1:]
+	}
+	fields := strings.Fields(statStr)
+	// 去掉 pid 和 comm 之后, utime, stime, starttime 分别是第 12, 13, 20 个字段
+	if len(fields) > 19 {
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		start, _ := strconv.ParseInt(fields[19], 10, 64)
+		p.CPUTime = time.Duration(utime+stime) * time.Second / clockTicks
+		p.StartTime = time.Duration(start) * time.Second / clockTicks
+		if elapsed := uptime - p.StartTime; elapsed > 0 {
+			p.CPU = float64(p.CPUTime) / float64(elapsed) * 100
+		}
+	}
+	if memTotal > 0 {
+		p.Mem = float64(p.RSS) / float64(memTotal) * 100
+	}
+
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err == nil && len(cmdline) > 0 {
+		p.Command = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	} else {
+		// 内核线程和僵尸进程没有 cmdline
+		p.Command = "[" + comm + "]"
+	}
+	return p, nil
+}
+
+func systemUptime() (time.Duration, error) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid /proc/uptime")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+func memTotalKB() int64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			total, _ := strconv.ParseInt(fields[1], 10, 64)
+			return total
+		}
+	}
+	return 0
+}
+
+// 和 ps 的 TIME 列一样显示为 [dd-]hh:mm:ss
+func formatCPUTime(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	days := seconds / 86400
+	seconds %= 86400
+	s := fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	if days > 0 {
+		s = fmt.Sprintf("%d-%s", days, s)
+	}
+	return s
+}
+
+// 在宿主机上执行 ps psArgs, 只保留 PID 列属于容器的行
+func runHostPs(pids []int, psArgs []string) error {
+	output, err := exec.Command("ps", psArgs...).Output()
+	if err != nil {
+		return fmt.Errorf("run ps %s error %v", strings.Join(psArgs, " "), err)
+	}
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	pidIndex := -1
+	for i, field := range strings.Fields(lines[0]) {
+		if field == "PID" {
+			pidIndex = i
+			break
+		}
+	}
+	if pidIndex < 0 {
+		return fmt.Errorf("couldn't find PID field in ps output")
+	}
+	inContainer := map[int]bool{}
+	for _, pid := range pids {
+		inContainer[pid] = true
+	}
+	fmt.Fprintln(os.Stdout, lines[0])
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) <= pidIndex {
+			continue
+		}
+		if pid, err := strconv.Atoi(fields[pidIndex]); err == nil && inContainer[pid] {
+			fmt.Fprintln(os.Stdout, line)
+		}
+	}
+	return nil
+}
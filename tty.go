@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"mydocker/term"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 前台 -ti 容器和 exec -ti 使用的伪终端会话
+// 宿主机终端进入 raw 模式, 输入输出在终端和 pty master 之间双向拷贝
+// 终端窗口大小变化时同步给 pty, 容器内的程序会收到 SIGWINCH
+type ttySession struct {
+	sync.Mutex
+	master   *os.File
+	oldState *term.State
+	output   sync.WaitGroup
+	winch    chan os.Signal
+}
+
+// 创建会话, 在整个前台运行期间只创建一次, 容器重启时复用
+func newTTYSession() *ttySession {
+	t := &ttySession{
+		winch: make(chan os.Signal, 1),
+	}
+	if term.IsTerminal(os.Stdin.Fd()) {
+		oldState, err := term.MakeRaw(os.Stdin.Fd())
+		if err != nil {
+			logrus.Warnf("Set terminal raw mode error %v", err)
+		}
+		t.oldState = oldState
+	}
+	signal.Notify(t.winch, syscall.SIGWINCH)
+	go t.forwardResize()
+	go t.copyInput()
+	return t
+}
+
+// 为容器进程分配 pty, 需要在 cmd.Start 之前调用
+// 返回的函数在 cmd.Start 之后调用, 关闭父进程中的 slave 端
+func (t *ttySession) attachProcess(cmd *exec.Cmd) (func(), error) {
+	master, slave, err := term.OpenPty()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// 子进程创建新的会话, 并把 slave (子进程中的 fd 0) 设为控制终端
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+
+	t.Lock()
+	t.master = master
+	t.Unlock()
+	t.resize()
+
+	t.output.Add(1)
+	go func() {
+		defer t.output.Done()
+		// slave 端全部关闭后读取 master 会返回 EIO
+		_, _ = io.Copy(os.Stdout, master)
+	}()
+	return func() {
+		slave.Close()
+	}, nil
+}
+
+// 进程退出后等待输出全部写完并关闭 master
+func (t *ttySession) detachProcess() {
+	t.output.Wait()
+	t.Lock()
+	defer t.Unlock()
+	if t.master != nil {
+		t.master.Close()
+		t.master = nil
+	}
+}
+
+// 恢复宿主机终端的设置
+func (t *ttySession) Close() {
+	signal.Stop(t.winch)
+	close(t.winch)
+	if t.oldState != nil {
+		_ = term.Restore(os.Stdin.Fd(), t.oldState)
+	}
+}
+
+func (t *ttySession) copyInput() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			t.Lock()
+			master := t.master
+			t.Unlock()
+			if master != nil {
+				_, _ = master.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (t *ttySession) forwardResize() {
+	for range t.winch {
+		t.resize()
+	}
+}
+
+// 把宿主机终端的窗口大小同步给 pty
+func (t *ttySession) resize() {
+	ws, err := term.GetWinsize(os.Stdin.Fd())
+	if err != nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.master != nil {
+		_ = term.SetWinsize(t.master.Fd(), ws)
+	}
+}
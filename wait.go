@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"mydocker/container"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// 轮询容器状态的间隔
+const waitPollInterval = 100 * time.Millisecond
+
+// 依次等待每个容器退出, 并输出它们的退出码
+func waitContainers(names []string) error {
+	failed := false
+	for _, name := range names {
+		exitCode, err := waitContainer(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error waiting container %s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintln(os.Stdout, exitCode)
+	}
+	if failed {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
+
+// 等到容器不再运行, 并且负责它的 monitor 进程已经记录好退出码
+func waitContainer(name string) (int, error) {
+	info, err := resolveContainer(name)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if info, err = getContainerInfoById(info.Id); err != nil {
+			return 0, fmt.Errorf("container %s was removed while waiting", name)
+		}
+		if containerExited(info) {
+			return info.State.ExitCode, nil
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
+
+// 容器不再运行, 并且 monitor 进程已经退出, 不会再有新的输出和状态变化
+func containerExited(info *container.ContainerInfo) bool {
+	running := info.Status == container.RUNNING || info.Status == container.RESTARTING
+	monitorAlive := info.MonitorPid > 0 && processExists(info.MonitorPid)
+	return !running && !monitorAlive
+}